@@ -0,0 +1,175 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// A bloom filter remembers which passwords were generated by earlier runs
+// without storing the passwords themselves.  It can answer "probably seen
+// before" for a password which never was (a false positive), which only
+// costs a regeneration.  It never answers "not seen" for a password which
+// was.  With the default size of 8 Mibit and 7 hashes the false positive
+// rate stays below 1% up to roughly 850,000 passwords.
+//
+// Anyone with the file can test guesses against it, so keep it as private
+// as the passwords themselves.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const bloomMagic string = "XKBF"
+const bloomDefaultBits uint64 = 8 * 1024 * 1024
+const bloomDefaultHashes uint32 = 7
+
+type BloomFilter struct {
+	NumBits			uint64
+	NumHashes		uint32
+	Bits			[]byte
+}
+
+func new_bloom_filter(numBits uint64, numHashes uint32) *BloomFilter {
+
+	return &BloomFilter{
+		NumBits:	numBits,
+		NumHashes:	numHashes,
+		Bits:		make([]byte, (numBits + 7) / 8),
+	}
+}
+
+// Returns the bit positions for the value using double hashing of its
+// SHA-256 digest
+func bloom_positions(filter *BloomFilter, value string) []uint64 {
+
+	var (
+		digest [32]byte
+		h1 uint64
+		h2 uint64
+		positions []uint64
+	)
+
+	digest = sha256.Sum256([]byte(value))
+	h1 = binary.BigEndian.Uint64(digest[0:8])
+	h2 = binary.BigEndian.Uint64(digest[8:16])
+
+	positions = make([]uint64, filter.NumHashes)
+	for i := uint64(0); i < uint64(filter.NumHashes); i++ {
+		positions[i] = (h1 + i * h2) % filter.NumBits
+	}
+
+	return positions
+}
+
+func bloom_add(filter *BloomFilter, value string) {
+
+	for _, position := range bloom_positions(filter, value) {
+		filter.Bits[position / 8] |= 1 << (position % 8)
+	}
+}
+
+func bloom_contains(filter *BloomFilter, value string) bool {
+
+	for _, position := range bloom_positions(filter, value) {
+		if filter.Bits[position / 8] & (1 << (position % 8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reads the filter from the file, or returns an empty filter if the file
+// does not exist yet
+func read_bloom_filter(filename string) (*BloomFilter, error) {
+
+	var (
+		content []byte
+		reader *bytes.Reader
+		magic [4]byte
+		filter BloomFilter
+		err error
+	)
+
+	content, err = ioutil.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return new_bloom_filter(bloomDefaultBits, bloomDefaultHashes), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	reader = bytes.NewReader(content)
+	err = binary.Read(reader, binary.BigEndian, &magic)
+	if err != nil || string(magic[:]) != bloomMagic {
+		return nil, errors.New(fmt.Sprintf("Error: %v is not a bloom filter file", filename))
+	}
+	err = binary.Read(reader, binary.BigEndian, &filter.NumBits)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error: %v is not a bloom filter file", filename))
+	}
+	err = binary.Read(reader, binary.BigEndian, &filter.NumHashes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error: %v is not a bloom filter file", filename))
+	}
+	if filter.NumBits == 0 || filter.NumHashes == 0 || uint64(reader.Len()) != (filter.NumBits + 7) / 8 {
+		return nil, errors.New(fmt.Sprintf("Error: The bloom filter file %v is corrupt", filename))
+	}
+	filter.Bits = make([]byte, reader.Len())
+	_, err = reader.Read(filter.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filter, nil
+}
+
+func write_bloom_filter(filename string, filter *BloomFilter) error {
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString(bloomMagic)
+	binary.Write(&buffer, binary.BigEndian, filter.NumBits)
+	binary.Write(&buffer, binary.BigEndian, filter.NumHashes)
+	buffer.Write(filter.Bits)
+
+	return ioutil.WriteFile(filename, buffer.Bytes(), 0600)
+}
+
+// Generates a password which the filter has not seen before and adds it
+func generate_unseen_password(defaults Defaults, filter *BloomFilter) (Password, error) {
+
+	var (
+		result Password
+		err error
+	)
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		result, err = generate_password_parts(defaults)
+		if err != nil {
+			return Password{}, err
+		}
+
+		if bloom_contains(filter, result.String) {
+			defaults.Log.Debugf("Rejecting password which was probably generated before")
+			continue
+		}
+
+		bloom_add(filter, result.String)
+		return result, nil
+	}
+
+	return Password{}, errors.New(fmt.Sprintf("Error: Could not generate an unseen password after %d attempts", maxAttempts))
+}
@@ -0,0 +1,108 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomAddContains(t *testing.T) {
+
+	var (
+		filter *BloomFilter = new_bloom_filter(bloomDefaultBits, bloomDefaultHashes)
+		falsePositives int = 0
+	)
+
+	for i := 0; i < 1000; i++ {
+		bloom_add(filter, fmt.Sprintf("added-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !bloom_contains(filter, fmt.Sprintf("added-%d", i)) {
+			t.Errorf("added-%d was added but is not contained", i)
+		}
+	}
+	// Far below the capacity, so false positives should be very rare
+	for i := 0; i < 1000; i++ {
+		if bloom_contains(filter, fmt.Sprintf("unseen-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 1 {
+		t.Errorf("%d of 1000 unseen values are contained", falsePositives)
+	}
+}
+
+func TestBloomFilterPersists(t *testing.T) {
+
+	var (
+		directory string = t.TempDir()
+		filename string = filepath.Join(directory, "seen.bloom")
+		corrupt string = filepath.Join(directory, "corrupt.bloom")
+	)
+
+	// A missing file is an empty filter
+	filter, err := read_bloom_filter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bloom_contains(filter, "correct-horse") {
+		t.Errorf("a new filter contains correct-horse")
+	}
+	bloom_add(filter, "correct-horse")
+	if err = write_bloom_filter(filename, filter); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := read_bloom_filter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.NumBits != filter.NumBits || loaded.NumHashes != filter.NumHashes {
+		t.Errorf("loaded %d bits and %d hashes, want %d and %d", loaded.NumBits, loaded.NumHashes, filter.NumBits, filter.NumHashes)
+	}
+	if !bloom_contains(loaded, "correct-horse") || bloom_contains(loaded, "battery-staple") {
+		t.Errorf("the loaded filter lost what was added")
+	}
+
+	if err = os.WriteFile(corrupt, []byte(bloomMagic), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = read_bloom_filter(corrupt); err == nil {
+		t.Errorf("read a truncated bloom filter without an error")
+	}
+}
+
+func TestBloomFileAcrossRuns(t *testing.T) {
+
+	var filename string = filepath.Join(t.TempDir(), "seen.bloom")
+
+	// The same seed would repeat the passwords, so the second run has to
+	// regenerate every one of them
+	_, first, _ := run_capture("", "-no-config", "-seed", "b100", "-bloom-file", filename, "5")
+	status, second, stderr := run_capture("", "-no-config", "-seed", "b100", "-bloom-file", filename, "5")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	seen := map[string]bool{}
+	for _, password := range output_lines(first) {
+		seen[password] = true
+	}
+	for _, password := range output_lines(second) {
+		if seen[password] {
+			t.Errorf("%v was generated by both runs", password)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The password is copied to the clipboard by the platform's own command,
+// given the password on stdin so that it never appears in the process
+// list: pbcopy on macOS, clip.exe on Windows and wl-copy, xclip or xsel
+// elsewhere.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The commands to try, in order, for the platform
+func clipboard_commands() [][]string {
+
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{ { "pbcopy" } }
+	case "windows":
+		return [][]string{ { "clip.exe" } }
+	default:
+		var commands [][]string
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			commands = append(commands, []string{ "wl-copy" })
+		}
+		return append(commands, []string{ "xclip", "-selection", "clipboard" }, []string{ "xsel", "--clipboard", "--input" })
+	}
+}
+
+// Copies the password to the clipboard with the first of the platform's
+// commands which is installed
+func copy_to_clipboard(password string) error {
+
+	var (
+		path string
+		command *exec.Cmd
+		names []string
+		err error
+	)
+
+	for _, args := range clipboard_commands() {
+		path, err = exec.LookPath(args[0])
+		if err != nil {
+			names = append(names, args[0])
+			continue
+		}
+		command = exec.Command(path, args[1:]...)
+		command.Stdin = strings.NewReader(password)
+		err = command.Run()
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error: %v could not copy to the clipboard: %v", args[0], err))
+		}
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("Error: No clipboard is available, install one of %v", strings.Join(names, ", ")))
+}
+
+// Replaceable so that copying can be pointed somewhere other than the
+// real clipboard
+var clipboardWriter func(password string) error = copy_to_clipboard
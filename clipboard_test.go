@@ -0,0 +1,95 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package main
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Points the clipboard at copied for the rest of the test
+func mock_clipboard(t *testing.T, copied *[]string, err error) {
+
+	var saved func(password string) error = clipboardWriter
+
+	clipboardWriter = func(password string) error {
+		*copied = append(*copied, password)
+		return err
+	}
+	t.Cleanup(func() { clipboardWriter = saved })
+}
+
+func TestCopy(t *testing.T) {
+
+	var copied []string
+
+	mock_clipboard(t, &copied, nil)
+
+	// Copied only, so nothing reaches the terminal
+	status, stdout, stderr := run_capture("", "-no-config", "-copy")
+	if status != ExitOK || stdout != "" {
+		t.Fatalf("-copy: status = %d, stdout = %q, stderr = %v", status, stdout, stderr)
+	}
+	if len(copied) != 1 || copied[0] == "" || strings.ContainsAny(copied[0], "\n") {
+		t.Fatalf("-copy copied %q", copied)
+	}
+
+	// The same password is printed with -print
+	copied = nil
+	status, stdout, stderr = run_capture("", "-no-config", "-copy", "-print")
+	if status != ExitOK || len(copied) != 1 || stdout != copied[0] + "\n" {
+		t.Errorf("-copy -print: status = %d, copied %q, stdout = %q, stderr = %v", status, copied, stdout, stderr)
+	}
+
+	// More than one password cannot be copied
+	copied = nil
+	status, _, _ = run_capture("", "-no-config", "-copy", "2")
+	if status != ExitUsage || len(copied) != 0 {
+		t.Errorf("-copy 2: status = %d, copied %q", status, copied)
+	}
+	status, _, _ = run_capture("", "-no-config", "-print")
+	if status != ExitUsage {
+		t.Errorf("-print without -copy: status = %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestCopyFails(t *testing.T) {
+
+	var copied []string
+
+	mock_clipboard(t, &copied, errors.New("Error: No clipboard is available"))
+
+	status, stdout, stderr := run_capture("", "-no-config", "-copy")
+	if status != ExitError || stdout != "" || !strings.Contains(stderr, "No clipboard is available") {
+		t.Errorf("status = %d, stdout = %q, stderr = %v", status, stdout, stderr)
+	}
+}
+
+func TestNoClipboardCommand(t *testing.T) {
+
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("the clipboard command is always installed")
+	}
+
+	// None of the commands can be found
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("WAYLAND_DISPLAY", "")
+
+	err := copy_to_clipboard("correct horse")
+	if err == nil || !strings.Contains(err.Error(), "No clipboard is available") || !strings.Contains(err.Error(), "xclip") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Words which are themselves among the most common passwords in published
+// breach corpora.  Passphrases built from them are no weaker in theory, but
+// they are the first words a cracker's wordlist tries.
+
+package main
+
+import (
+	"strings"
+)
+
+var commonWeakWords = []string{
+	"access", "admin", "amanda", "andrew", "angel", "anthony", "apple",
+	"ashley", "austin", "bailey", "banana", "baseball", "batman", "biteme",
+	"buster", "charlie", "cheese", "chelsea", "chicken", "coffee", "computer",
+	"cookie", "cowboy", "daniel", "dragon", "eagle", "flower", "football",
+	"freedom", "friend", "george", "ginger", "golfer", "hammer", "hannah",
+	"harley", "hello", "hockey", "hunter", "iloveyou", "jennifer", "jessica",
+	"jordan", "joshua", "killer", "letmein", "login", "lovely", "maggie",
+	"master", "matrix", "matthew", "merlin", "michael", "michelle", "monkey",
+	"mustang", "nicole", "orange", "passw0rd", "password", "pepper", "princess",
+	"purple", "qwerty", "ranger", "robert", "secret", "shadow", "soccer",
+	"starwars", "summer", "sunshine", "superman", "taylor", "thomas", "thunder",
+	"tigger", "trustno1", "welcome", "whatever", "william", "yankees", "zxcvbnm",
+}
+
+// Returns the dictionary without any of the words, compared case
+// insensitively
+func remove_words(dictionary []string, words []string) []string {
+
+	var (
+		remove map[string]bool
+		result []string
+	)
+
+	remove = make(map[string]bool, len(words))
+	for _, word := range words {
+		remove[strings.ToLower(word)] = true
+	}
+
+	result = make([]string, 0, len(dictionary))
+	for _, word := range dictionary {
+		if !remove[strings.ToLower(word)] {
+			result = append(result, word)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,59 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemoveWords(t *testing.T) {
+
+	var got []string = remove_words([]string{ "Dragon", "kettle", "password", "MONKEY", "lantern" }, commonWeakWords)
+
+	if strings.Join(got, " ") != "kettle lantern" {
+		t.Errorf("remove_words = %q, want the words which are not weak", got)
+	}
+}
+
+func TestFilterCommonWeak(t *testing.T) {
+
+	var list string = filepath.Join(t.TempDir(), "words.txt")
+
+	// Mostly weak words, so a password from the unfiltered list would
+	// almost certainly use one
+	if err := os.WriteFile(list, []byte("dragon\nmonkey\nshadow\nsummer\nsecret\nkettle\nlantern\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-dictionary", list, "-filter-common-weak", "-case", "lower", "-format", "{{range .WordList}}{{.}} {{end}}", "20")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		for _, word := range strings.Fields(line) {
+			if word != "kettle" && word != "lantern" {
+				t.Errorf("%q uses %q, which -filter-common-weak should have removed", line, word)
+			}
+		}
+	}
+
+	if err := os.WriteFile(list, []byte("dragon\nmonkey\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ = run_capture("", "-no-config", "-dictionary", list, "-filter-common-weak"); status != ExitDictionary {
+		t.Errorf("only weak words: status = %d, want %d", status, ExitDictionary)
+	}
+}
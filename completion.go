@@ -0,0 +1,150 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The hidden completion subcommand prints a bash or zsh completion script
+// covering every flag, as in
+//
+//	source <(xkcd-passwd completion bash)
+//
+// Flags with a fixed set of values complete them, and -profile completes
+// the profiles in the user configuration directory when the script runs.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The values completed for flags which only take a few
+func completion_values() map[string][]string {
+
+	var (
+		presetNames []string
+		layouts []string
+	)
+
+	for name := range presets {
+		presetNames = append(presetNames, name)
+	}
+	sort.Strings(presetNames)
+	for layout := range keyboardLayouts {
+		layouts = append(layouts, layout)
+	}
+	sort.Strings(layouts)
+
+	return map[string][]string{
+		"case":			{ "none", "alternate", "capitalise", "invert", "upper", "lower", "random", "first", "word-random", "title", "syllable" },
+		"separator":		{ "none", "random" },
+		"log-level":		{ "error", "warn", "info", "debug" },
+		"merge-strategy":	{ "union", "intersect", "concat" },
+		"mode":			{ "dictionary", "syllable" },
+		"word-selection":	{ "uniform", "length" },
+		"digit-placement":	{ "both", "before", "after", "between-all", "random-gap" },
+		"keyboard-layout":	layouts,
+		"preset":		presetNames,
+	}
+}
+
+// Flags which take a file name
+var completionFileFlags = map[string]bool{
+	"config":		true,
+	"exclude-words":	true,
+	"output":		true,
+	"bloom-file":		true,
+}
+
+// The shell code listing the profiles, shared by both scripts
+const completionProfiles string = `ls "${XDG_CONFIG_HOME:-$HOME/.config}/xkcd-passwd" 2>/dev/null | sed -n 's/\.json$//p'`
+
+func is_bool_flag(f *flag.Flag) bool {
+
+	var boolFlag interface{ IsBoolFlag() bool }
+	var found bool
+
+	boolFlag, found = f.Value.(interface{ IsBoolFlag() bool })
+
+	return found && boolFlag.IsBoolFlag()
+}
+
+// Writes the completion script for the shell, bash or zsh, completing the
+// flags of flags for the program
+func write_completion(out io.Writer, shell string, program string, flags *flag.FlagSet) error {
+
+	var (
+		values map[string][]string = completion_values()
+		all []*flag.Flag
+		function string = "_" + strings.ReplaceAll(program, "-", "_")
+	)
+
+	flags.VisitAll(func(f *flag.Flag) {
+		all = append(all, f)
+	})
+
+	switch shell {
+	case "bash":
+		var names []string
+		fmt.Fprintf(out, "%v() {\n", function)
+		fmt.Fprintf(out, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+		fmt.Fprintf(out, "\tcase \"$prev\" in\n")
+		for _, f := range all {
+			names = append(names, "-" + f.Name)
+			if choices, found := values[f.Name]; found {
+				fmt.Fprintf(out, "\t-%v) COMPREPLY=($(compgen -W \"%v\" -- \"$cur\")); return ;;\n", f.Name, strings.Join(choices, " "))
+			} else if completionFileFlags[f.Name] {
+				fmt.Fprintf(out, "\t-%v) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", f.Name)
+			} else if f.Name == "profile" {
+				fmt.Fprintf(out, "\t-%v) COMPREPLY=($(compgen -W \"$(%v)\" -- \"$cur\")); return ;;\n", f.Name, completionProfiles)
+			}
+		}
+		fmt.Fprintf(out, "\tconfig) COMPREPLY=($(compgen -W \"print validate\" -- \"$cur\")); return ;;\n")
+		fmt.Fprintf(out, "\tesac\n")
+		fmt.Fprintf(out, "\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+		fmt.Fprintf(out, "\t\tCOMPREPLY=($(compgen -W \"generate config entropy %v\" -- \"$cur\"))\n", strings.Join(names, " "))
+		fmt.Fprintf(out, "\telse\n")
+		fmt.Fprintf(out, "\t\tCOMPREPLY=($(compgen -W \"%v\" -- \"$cur\"))\n", strings.Join(names, " "))
+		fmt.Fprintf(out, "\tfi\n")
+		fmt.Fprintf(out, "}\n")
+		fmt.Fprintf(out, "complete -F %v %v\n", function, program)
+	case "zsh":
+		fmt.Fprintf(out, "#compdef %v\n\n", program)
+		fmt.Fprintf(out, "%v() {\n", function)
+		fmt.Fprintf(out, "\t_arguments \\\n")
+		for _, f := range all {
+			// Brackets, colons and quotes would end the specification early
+			var usage = strings.NewReplacer("[", "\\[", "]", "\\]", ":", "\\:", "'", "'\\''").Replace(f.Usage)
+			if is_bool_flag(f) {
+				fmt.Fprintf(out, "\t\t'-%v[%v]' \\\n", f.Name, usage)
+			} else if choices, found := values[f.Name]; found {
+				fmt.Fprintf(out, "\t\t'-%v[%v]:%v:(%v)' \\\n", f.Name, usage, f.Name, strings.Join(choices, " "))
+			} else if completionFileFlags[f.Name] {
+				fmt.Fprintf(out, "\t\t'-%v[%v]:%v:_files' \\\n", f.Name, usage, f.Name)
+			} else if f.Name == "profile" {
+				fmt.Fprintf(out, "\t\t'-%v[%v]:%v:($(%v))' \\\n", f.Name, usage, f.Name, strings.ReplaceAll(completionProfiles, "'", "'\\''"))
+			} else {
+				fmt.Fprintf(out, "\t\t'-%v[%v]:%v: ' \\\n", f.Name, usage, f.Name)
+			}
+		}
+		fmt.Fprintf(out, "\t\t'1::command:(generate config entropy)' \\\n")
+		fmt.Fprintf(out, "\t\t'*::argument: '\n")
+		fmt.Fprintf(out, "}\n\n")
+		fmt.Fprintf(out, "%v \"$@\"\n", function)
+	default:
+		return errors.New(fmt.Sprintf("Error: Unknown shell %v for completion (bash, zsh)", shell))
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCompletion(t *testing.T) {
+
+	var (
+		stdout, stderr bytes.Buffer
+		logMain *logrus.Logger = &logrus.Logger{ Out: &stderr, Formatter: new(logrus.TextFormatter), Level: logrus.ErrorLevel }
+		names []string
+	)
+
+	// Every flag the program has
+	options, _, _ := parse_options([]string{ "-no-config" }, &stdout, &stderr, logMain)
+	options.Flags.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	if len(names) < 50 {
+		t.Fatalf("only %d flags were found", len(names))
+	}
+
+	for _, shell := range []string{ "bash", "zsh" } {
+		status, script, stderr := run_capture("", "completion", shell)
+		if status != ExitOK || script == "" {
+			t.Fatalf("%v: status = %d, stderr = %v", shell, status, stderr)
+		}
+		for _, name := range names {
+			if !strings.Contains(script, "-" + name) {
+				t.Errorf("%v: the script does not complete -%v", shell, name)
+			}
+		}
+		// The fixed values and the profiles
+		for _, value := range []string{ "capitalise", "between-all", "XKCD", "xkcd-passwd\"" } {
+			if !strings.Contains(script, value) {
+				t.Errorf("%v: the script does not complete %v", shell, value)
+			}
+		}
+	}
+
+	if status, _, _ := run_capture("", "completion", "fish"); status != ExitUsage {
+		t.Errorf("completion fish: status = %d, want %d", status, ExitUsage)
+	}
+	if status, _, _ := run_capture("", "completion"); status != ExitUsage {
+		t.Errorf("completion: status = %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestBashCompletionParses(t *testing.T) {
+
+	var script string = filepath.Join(t.TempDir(), "xkcd-passwd.bash")
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	_, stdout, _ := run_capture("", "completion", "bash")
+	if err = os.WriteFile(script, []byte(stdout), 0600); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command(bash, "-n", script).CombinedOutput()
+	if err != nil {
+		t.Errorf("bash -n: %v: %s", err, output)
+	}
+}
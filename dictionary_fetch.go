@@ -0,0 +1,157 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Word lists given as a URL are downloaded into a cache directory along
+// with their ETag and Last-Modified headers, so later runs only download
+// them again when the server says they changed.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The largest word list downloaded, far more than any real one, so that a
+// misbehaving server cannot fill the memory or the cache
+const maxDictionaryDownload int64 = 64 * 1024 * 1024
+
+func is_url(value string) bool {
+
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
+// Returns the directory cached word lists are kept in
+func dictionary_cache_dir() (string, error) {
+
+	var (
+		cacheDir string
+		err error
+	)
+
+	cacheDir, err = os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "xkcd-passwd"), nil
+}
+
+// Downloads the word list at the url into cacheDir, unless the cached copy
+// is still current, and returns the name of the cached file
+func fetch_dictionary(url string, cacheDir string, log *logrus.Logger) (string, error) {
+
+	var (
+		digest [32]byte
+		filename string
+		etagFilename string
+		modifiedFilename string
+		etag []byte
+		modified []byte
+		haveCache bool
+		client *http.Client
+		request *http.Request
+		response *http.Response
+		content []byte
+		err error
+	)
+
+	// Anyone on the path could swap the words for ones they know
+	if strings.HasPrefix(url, "http://") {
+		log.Warnf("Downloading %v without TLS, so the word list could be tampered with; use https:// instead", url)
+	}
+
+	digest = sha256.Sum256([]byte(url))
+	filename = filepath.Join(cacheDir, hex.EncodeToString(digest[:]))
+	etagFilename = filename + ".etag"
+	modifiedFilename = filename + ".last-modified"
+
+	_, err = os.Stat(filename)
+	haveCache = err == nil
+
+	request, err = http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if haveCache {
+		etag, err = ioutil.ReadFile(etagFilename)
+		if err == nil && len(etag) > 0 {
+			request.Header.Set("If-None-Match", string(etag))
+		}
+		modified, err = ioutil.ReadFile(modifiedFilename)
+		if err == nil && len(modified) > 0 {
+			request.Header.Set("If-Modified-Since", string(modified))
+		}
+	}
+
+	client = &http.Client{ Timeout: 30 * time.Second }
+	response, err = client.Do(request)
+	if err != nil {
+		if haveCache {
+			log.Warnf("Using the cached copy of %v: %v", url, err)
+			return filename, nil
+		}
+		return "", err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		if !haveCache {
+			return "", errors.New(fmt.Sprintf("Error: %v was not modified but there is no cached copy", url))
+		}
+		log.Debugf("Using the cached copy of %v", url)
+		return filename, nil
+	case http.StatusOK:
+	default:
+		return "", errors.New(fmt.Sprintf("Error: Downloading %v returned %v", url, response.Status))
+	}
+
+	content, err = ioutil.ReadAll(io.LimitReader(response.Body, maxDictionaryDownload + 1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(content)) > maxDictionaryDownload {
+		return "", errors.New(fmt.Sprintf("Error: %v is larger than %d bytes", url, maxDictionaryDownload))
+	}
+
+	err = os.MkdirAll(cacheDir, 0700)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(filename, content, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(etagFilename, []byte(response.Header.Get("ETag")), 0600)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(modifiedFilename, []byte(response.Header.Get("Last-Modified")), 0600)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Downloaded %v into %v", url, filename)
+
+	return filename, nil
+}
@@ -0,0 +1,92 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Serves a word list with an ETag, answering 304 Not Modified when the
+// request already has it, and records the status of every response
+func word_list_server(t *testing.T, statuses *[]int) *httptest.Server {
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			*statuses = append(*statuses, http.StatusNotModified)
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+		*statuses = append(*statuses, http.StatusOK)
+		writer.Header().Set("ETag", `"v1"`)
+		writer.Write([]byte("alpha\nbravo\ncharlie\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetchDictionaryCaches(t *testing.T) {
+
+	var (
+		statuses []int
+		server *httptest.Server = word_list_server(t, &statuses)
+		cacheDir string = t.TempDir()
+	)
+
+	first, err := fetch_dictionary(server.URL + "/words.txt", cacheDir, discard_logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := fetch_dictionary(server.URL + "/words.txt", cacheDir, discard_logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("the cached copy moved from %v to %v", first, second)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusOK || statuses[1] != http.StatusNotModified {
+		t.Errorf("the server answered %v, want 200 then 304", statuses)
+	}
+
+	words, err := read_dictionary(second)
+	if err != nil || strings.Join(words, " ") != "alpha bravo charlie" {
+		t.Errorf("the cached copy has %q, %v", words, err)
+	}
+}
+
+func TestRunDictionaryURL(t *testing.T) {
+
+	var (
+		statuses []int
+		server *httptest.Server = word_list_server(t, &statuses)
+	)
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	for i := 0; i < 2; i++ {
+		status, stdout, stderr := run_capture("", "-no-config", "-dictionary", server.URL + "/words.txt", "-min-length", "5", "-max-length", "7", "-case", "lower", "-format", "{{index .WordList 0}}")
+		if status != ExitOK {
+			t.Fatalf("run %d: status = %d, stderr = %v", i + 1, status, stderr)
+		}
+		if word := strings.TrimSpace(stdout); !strings.Contains(" alpha bravo charlie ", " " + word + " ") {
+			t.Errorf("run %d: %q is not from the word list", i + 1, word)
+		}
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusOK || statuses[1] != http.StatusNotModified {
+		t.Errorf("the server answered %v, want 200 then 304", statuses)
+	}
+}
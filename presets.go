@@ -0,0 +1,139 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Named presets, modelled on the ones xkpasswd ships, give newcomers a
+// starting point without writing a defaults file.  Each one is the JSON a
+// defaults file would hold, so it is read by read_defaults like any other.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type Preset struct {
+	Summary			string
+	JSON			string
+}
+
+var presets = map[string]Preset{
+	"APPLEID": {
+		Summary: "Three words with symbols and digits, suited to an Apple ID",
+		JSON: `{
+ "num_words": 3, "word_length_min": 4, "word_length_max": 7,
+ "case_transform": "random",
+ "separator_character": "random", "separator_alphabet": ["-", ":", ".", "@", "&"],
+ "padding_digits_before": 2, "padding_digits_after": 2,
+ "padding_type": "fixed", "padding_character": "random", "symbol_alphabet": ["-", ":", ".", "!", "?", "@", "&"],
+ "padding_characters_before": 1, "padding_characters_after": 1
+}`,
+	},
+	"NTLM": {
+		Summary: "Two short words, 14 characters at most, for old Windows systems",
+		JSON: `{
+ "num_words": 2, "word_length_min": 5, "word_length_max": 5,
+ "case_transform": "invert",
+ "separator_character": "random", "separator_alphabet": ["-", "+", "=", ".", "*", "_", "|", "~", ","],
+ "padding_digits_before": 1, "padding_digits_after": 0,
+ "padding_type": "fixed", "padding_character": "random", "symbol_alphabet": ["!", "@", "$", "%", "^", "&", "*", "+", "=", ":", "|", "~", "?"],
+ "padding_characters_before": 0, "padding_characters_after": 1
+}`,
+	},
+	"SECURITYQ": {
+		Summary: "A sentence of six lowercase words, for security question answers",
+		JSON: `{
+ "num_words": 6, "word_length_min": 4, "word_length_max": 8,
+ "case_transform": "none",
+ "separator_character": " ", "separator_alphabet": [],
+ "padding_digits_before": 0, "padding_digits_after": 0,
+ "padding_type": "fixed", "padding_character": "random", "symbol_alphabet": [".", "!", "?"],
+ "padding_characters_before": 0, "padding_characters_after": 1
+}`,
+	},
+	"WEB16": {
+		Summary: "Three four letter words and two digits, for sites with a short length limit",
+		JSON: `{
+ "num_words": 3, "word_length_min": 4, "word_length_max": 4,
+ "case_transform": "random",
+ "separator_character": "random", "separator_alphabet": ["!", "@", "$", "%", "^", "&", "*", "-", "_", "+", "=", ":", "|", "~", "?", "/", ".", ";"],
+ "padding_digits_before": 0, "padding_digits_after": 2,
+ "padding_type": "none", "padding_character": "random", "symbol_alphabet": []
+}`,
+	},
+	"WEB32": {
+		Summary: "Four words with digits and symbols, 32 characters at most, for most sites",
+		JSON: `{
+ "num_words": 4, "word_length_min": 4, "word_length_max": 5,
+ "case_transform": "alternate",
+ "separator_character": "random", "separator_alphabet": ["-", "+", "=", ".", "*", "_", "|", "~", ","],
+ "padding_digits_before": 2, "padding_digits_after": 2,
+ "padding_type": "fixed", "padding_character": "random", "symbol_alphabet": ["!", "@", "$", "%", "^", "&", "*", "+", "=", ":", "|", "~", "?"],
+ "padding_characters_before": 1, "padding_characters_after": 1
+}`,
+	},
+	"WIFI": {
+		Summary: "Six words padded to 63 characters, the longest WPA2 passphrase",
+		JSON: `{
+ "num_words": 6, "word_length_min": 4, "word_length_max": 8,
+ "case_transform": "random",
+ "separator_character": "random", "separator_alphabet": ["-", "+", "=", ".", "*", "_", "|", "~", ","],
+ "padding_digits_before": 4, "padding_digits_after": 4,
+ "padding_type": "adaptive", "padding_character": "random", "symbol_alphabet": ["!", "@", "$", "%", "^", "&", "*", "+", "=", ":", "|", "~", "?"],
+ "pad_to_length": 63
+}`,
+	},
+	"XKCD": {
+		Summary: "Four lowercase words joined by dashes, as in the original comic",
+		JSON: `{
+ "num_words": 4, "word_length_min": 4, "word_length_max": 8,
+ "case_transform": "lower",
+ "separator_character": "-", "separator_alphabet": [],
+ "padding_digits_before": 0, "padding_digits_after": 0,
+ "padding_type": "none", "padding_character": "random", "symbol_alphabet": []
+}`,
+	},
+}
+
+// Returns the JSON of the named preset, whatever its case
+func find_preset(name string) ([]byte, error) {
+
+	var (
+		preset Preset
+		found bool
+	)
+
+	preset, found = presets[strings.ToUpper(name)]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("Error: Unknown preset %v, see -list-presets", name))
+	}
+
+	return []byte(preset.JSON), nil
+}
+
+// Writes the name and summary of every preset, one per line
+func list_presets(out io.Writer) {
+
+	var names []string
+
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(out, "%-10v %v\n", name, presets[name].Summary)
+	}
+}
@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// A seeded reader replaces crypto/rand with a deterministic stream so that
+// auditors can reproduce the output of a pipeline.  Block i of the stream
+// is the SHA-256 digest of the seed followed by i as a big endian counter.
+// Anyone who learns the seed can regenerate every password, so it is only
+// for verification and never for real passwords.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type SeededReader struct {
+	Seed			[]byte
+	Counter			uint64
+	Block			[]byte
+	Lock			sync.Mutex
+}
+
+// Parses the hex seed given to -seed
+func new_seeded_reader(seed string) (*SeededReader, error) {
+
+	var (
+		decoded []byte
+		err error
+	)
+
+	decoded, err = hex.DecodeString(seed)
+	if err != nil || len(decoded) == 0 {
+		return nil, errors.New(fmt.Sprintf("Error: The seed must be a non empty hex string (%v)", seed))
+	}
+
+	return &SeededReader{ Seed: decoded }, nil
+}
+
+func (reader *SeededReader) Read(buffer []byte) (int, error) {
+
+	var (
+		digest [32]byte
+		counter [8]byte
+		n int
+	)
+
+	reader.Lock.Lock()
+	defer reader.Lock.Unlock()
+
+	for n < len(buffer) {
+		if len(reader.Block) == 0 {
+			binary.BigEndian.PutUint64(counter[:], reader.Counter)
+			digest = sha256.Sum256(append(append([]byte{}, reader.Seed...), counter[:]...))
+			reader.Block = digest[:]
+			reader.Counter++
+		}
+		copy(buffer[n:], reader.Block)
+		if len(reader.Block) > len(buffer) - n {
+			reader.Block = reader.Block[len(buffer) - n:]
+			n = len(buffer)
+		} else {
+			n += len(reader.Block)
+			reader.Block = nil
+		}
+	}
+
+	return n, nil
+}
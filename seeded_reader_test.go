@@ -0,0 +1,104 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Reads n bytes from a new reader for the seed
+func seeded_bytes(t *testing.T, seed string, n int) []byte {
+
+	var (
+		reader *SeededReader
+		buffer []byte = make([]byte, n)
+		err error
+	)
+
+	reader, err = new_seeded_reader(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(reader, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer
+}
+
+func TestSeededReaderIsReproducible(t *testing.T) {
+
+	if !bytes.Equal(seeded_bytes(t, "00ff", 100), seeded_bytes(t, "00ff", 100)) {
+		t.Errorf("the same seed gave different streams")
+	}
+	if bytes.Equal(seeded_bytes(t, "00ff", 100), seeded_bytes(t, "00fe", 100)) {
+		t.Errorf("different seeds gave the same stream")
+	}
+}
+
+func TestSeededReaderReadSizes(t *testing.T) {
+
+	var (
+		reader *SeededReader
+		whole []byte = seeded_bytes(t, "abcd", 100)
+		pieces []byte
+		err error
+	)
+
+	// Reads which split and span the 32 byte blocks give the same stream
+	reader, err = new_seeded_reader("abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{ 1, 7, 32, 40, 20 } {
+		var buffer []byte = make([]byte, size)
+		if _, err = reader.Read(buffer); err != nil {
+			t.Fatal(err)
+		}
+		pieces = append(pieces, buffer...)
+	}
+	if !bytes.Equal(whole, pieces) {
+		t.Errorf("reading in pieces gave a different stream")
+	}
+}
+
+func TestNewSeededReaderRejectsBadSeeds(t *testing.T) {
+
+	for _, seed := range []string{ "", "xyz", "abc" } {
+		if _, err := new_seeded_reader(seed); err == nil {
+			t.Errorf("new_seeded_reader(%q) returned no error", seed)
+		}
+	}
+}
+
+func TestRunSeedIsReproducible(t *testing.T) {
+
+	var outputs = map[string]string{}
+
+	for _, seed := range []string{ "01", "01", "02" } {
+		status, stdout, stderr := run_capture("", "-no-config", "-seed", seed, "5")
+		if status != ExitOK {
+			t.Fatalf("seed %v: status = %d, stderr = %v", seed, status, stderr)
+		}
+		if previous, found := outputs[seed]; found && previous != stdout {
+			t.Errorf("seed %v gave %q then %q", seed, previous, stdout)
+		}
+		outputs[seed] = stdout
+	}
+	if outputs["01"] == outputs["02"] {
+		t.Errorf("seeds 01 and 02 gave the same passwords %q", outputs["01"])
+	}
+}
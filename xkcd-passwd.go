@@ -17,6 +17,9 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
@@ -28,27 +31,41 @@ import (
 	"math"
 	"math/big"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Replaced with:
 //   -ldflags="-X main.version=$(git describe --always --long --dirty) -X main.release=$(git tag --sort=-version:refname | head -n1)"
 var version string = "undefined"
 var release string = "undefined"
-var shouldDebug bool = false
-var log *logrus.Logger
+
+// How many times to regenerate a password which breaks a rule before giving up
+const maxAttempts int = 100
 
 type CaseType int
 const (
 	CaseNone	CaseType = iota		// case - all lowercase
-	CaseAlternate				// CaSe - first character is upper case, second is lowercase, repeat
+	CaseAlternate				// CaSe - first letter is upper case, second is lowercase, repeat, skipping non-letters
 	CaseCapitalise				// Case - first character is uppercase, rest are lowercase
 	CaseInvert				// cASE - first character is lowercase, rest are uppercase
 	CaseUpper				// CASE - all uppercase
 	CaseRandom				// cASe - every character is randomly upper or lower
+	CaseFirstLetter				// CaSe - first character is uppercase, rest are untouched
+	CaseWordRandom				// Case - every word is randomly capitalised or lowercase
+	CaseTitle				// CaSe - first letter of every word in the password is uppercase, rest are untouched
+	CaseSyllable				// BatTeRy - first letter of every syllable is uppercase, rest are lowercase
 )
 const CaseLower CaseType = CaseNone
 
@@ -64,8 +81,38 @@ const (
 	PaddingNone		PaddingType = iota
 	PaddingFixed
 	PaddingAdaptive
+	PaddingMultiple				// Pad up to the next multiple of pad_to_multiple
+)
+
+type DigitPlacement int
+const (
+	DigitsBoth		DigitPlacement = iota	// Before the first word and after the last
+	DigitsBefore					// Only before the first word
+	DigitsAfter					// Only after the last word
+	DigitsBetweenAll				// Between every pair of words
+	DigitsRandomGap					// Between one random pair of words
+)
+
+type WordMode int
+const (
+	WordsDictionary		WordMode = iota		// Draw words from the dictionary
+	WordsSyllable					// Build words from consonant-vowel syllables
+)
+
+type WordSelection int
+const (
+	SelectUniform		WordSelection = iota	// Every candidate word is equally likely
+	SelectByLength					// Draw a length, then a word of that length
 )
 
+// The letters WordsSyllable builds syllables from
+const syllableConsonants string = "bcdfghjklmnprstvz"
+const syllableVowels string = "aeiou"
+
+// A dictionary with fewer than this many words per word in the password
+// is warned about, unless min_word_variety says otherwise
+const defaultMinWordVariety int = 100
+
 type PaddingCharacter int
 const (
 	PaddingRandom		PaddingCharacter = iota		// Use symbol_alphabet
@@ -73,6 +120,25 @@ const (
 	PaddingSpecified					// Use the string value
 )
 
+// The substitutions leet_probability uses when there is no leet_map
+var defaultLeetMap = map[rune]string{
+	'a':	"@",
+	'e':	"3",
+	'i':	"1",
+	'o':	"0",
+	's':	"$",
+	't':	"7",
+}
+
+// Symbols which can be typed on each keyboard layout without AltGr or
+// Option, either directly or with shift
+var keyboardLayouts = map[string]string{
+	"us":	"`~!@#$%^&*()-_=+[]{}\\|;:'\",.<>/?",
+	"uk":	"`¬!\"£$%^&*()-_=+[]{};:'@#~\\|,.<>/?",
+	"de":	"^°!\"§$%&/()=?´`+*#'-_.:,;<>",
+	"fr":	"²&\"'(-_)=°+^¨$£%*µ,?;.:/!§<>",
+}
+
 // https://www.digitalocean.com/community/tutorials/how-to-use-json-in-go
 type JSON_Defaults struct {
 	NumWords		int		`json:"num_words"`
@@ -81,460 +147,4866 @@ type JSON_Defaults struct {
 	CaseTransform		string		`json:"case_transform"`
 	SeparatorCharacter	string		`json:"separator_character"`
 	SeparatorAlphabet	[]string	`json:"separator_alphabet"`
+	SeparatorWeights	[]float64	`json:"separator_weights,omitempty"`
 	PaddingDigitsBefore	int		`json:"padding_digits_before"`
 	PaddingDigitsAfter	int		`json:"padding_digits_after"`
+	PaddingDigitsBeforeMax	int		`json:"padding_digits_before_max,omitempty"`
+	PaddingDigitsAfterMax	int		`json:"padding_digits_after_max,omitempty"`
 	PaddingType		string		`json:"padding_type"`
 	PaddingCharacter	string		`json:"padding_character"`
 	SymbolAlphabet		[]string	`json:"symbol_alphabet"`
 	PaddingCharactersBefore	int		`json:"padding_characters_before"`
 	PaddingCharactersAfter	int		`json:"padding_characters_after"`
+	PaddingCharsBeforeMax	int		`json:"padding_characters_before_max,omitempty"`
+	PaddingCharsAfterMax	int		`json:"padding_characters_after_max,omitempty"`
 	PadToLength		int		`json:"pad_to_length"`
+	MaxIdenticalAdjacent	int		`json:"max_identical_adjacent,omitempty"`
+	SeparatePaddingDigits	bool		`json:"separate_padding_digits,omitempty"`
+	MinTotalLength		int		`json:"min_total_length,omitempty"`
+	InjectSymbolProbability	float64		`json:"inject_symbol_probability,omitempty"`
+	MaxTotalLength		int		`json:"max_total_length,omitempty"`
+	OnlyLetters		bool		`json:"only_letters,omitempty"`
+	LengthHistogram		map[string]float64	`json:"length_histogram,omitempty"`
+	NoDuplicateWords	bool		`json:"no_duplicate_words,omitempty"`
+	PadToMultiple		int		`json:"pad_to_multiple,omitempty"`
+	SeparatorSequence	[]string	`json:"separator_sequence,omitempty"`
+	LeetProbability		float64		`json:"leet_probability,omitempty"`
+	LeetMap			map[string]string	`json:"leet_map,omitempty"`
+	Mode			string		`json:"mode,omitempty"`
+	SyllablesPerWord	int		`json:"syllables_per_word,omitempty"`
+	UppercaseRatio		*float64	`json:"uppercase_ratio,omitempty"`
+	MinWordVariety		*int		`json:"min_word_variety,omitempty"`
+	DigitAlphabet		[]string	`json:"digit_alphabet,omitempty"`
+	AmbiguousCharacters	string		`json:"ambiguous_characters,omitempty"`
+	DigitPlacement		string		`json:"digit_placement,omitempty"`
+	WordSelection		string		`json:"word_selection,omitempty"`
+	LengthWeights		map[string]float64	`json:"length_weights,omitempty"`
 }
 
 type Defaults struct {
 	WordDictionary		[]string
+	WordCandidates		[]string
 	NumWords		int
 	WordLengthMin		int
 	WordLengthMax		int
 	CaseTransform		CaseType
 	SeparatorCharacter	SeparatorType
 	SeparatorAlphabet	[]string
+	SeparatorWeights	[]float64
 	PaddingDigitsBefore	int
 	PaddingDigitsAfter	int
+	PaddingDigitsBeforeMax	int
+	PaddingDigitsAfterMax	int
 	PaddingType		PaddingType
 	PaddingCharacter	PaddingCharacter
 	SymbolAlphabet		[]string
 	PaddingCharactersBefore	int
 	PaddingCharactersAfter	int
+	PaddingCharsBeforeMax	int
+	PaddingCharsAfterMax	int
 	PadToLength		int
+	MaxIdenticalAdjacent	int
+	SeparatePaddingDigits	bool
+	MinTotalLength		int
+	InjectSymbolProbability	float64
+	MaxTotalLength		int
+	OnlyLetters		bool
+	HistogramLengths	[]int
+	HistogramWeights	[]float64
+	NoDuplicateWords	bool
+	PadToMultiple		int
+	SeparatorSequence	[]string
+	LeetProbability		float64
+	LeetMap			map[rune]string
+	Mode			WordMode
+	SyllablesPerWord	int
+	UppercaseRatio		float64
+	MinWordVariety		int
+	DigitAlphabet		[]string
+	AmbiguousCharacters	string
+	DigitPlacement		DigitPlacement
+	WordSelection		WordSelection
+	LengthWeights		map[int]float64
+	WordBuckets		[][]string	// The candidates by length, for SelectByLength
+	BucketWeights		[]float64
+	DigitsBounds		map[int]*big.Int	// From digits_bounds, shared by every password
+	Format			*template.Template
+	Log			*logrus.Logger
+	Random			io.Reader		// The source of all randomness
 }
 
-func read_defaults(jsonData []byte) (Defaults, error) {
+// The parts a password was built from, along with the password itself.
+// Putting the parts together in the default order gives String, unless a
+// format, title case, injected symbols or adaptive padding changed it.
+type Password struct {
+	Words			[]string
+	DigitsBefore		string
+	DigitsAfter		string
+	Separator		string
+	WordSeparators		[]string	// The separator after each word but the last
+	DigitsBetween		[]string	// The digits after each word but the last, if any
+	Padding			string		// The padding symbol
+	PaddingBefore		int		// How many padding symbols come before, for fixed padding
+	PaddingAfter		int
+	String			string
+}
 
-	var json_defaults JSON_Defaults
-	var defaults Defaults
-	var err error
-	err = json.Unmarshal(jsonData, &json_defaults)
-	if err != nil {
-		return Defaults{}, err
-	}
-	log.Printf("json_defaults: %+v\n", json_defaults)
+// The values a -format template can use.  Padding is only filled in for
+// fixed padding.
+type FormatData struct {
+	Words			string		// The words joined by their separators
+	WordList		[]string
+	Separator		string
+	DigitsBefore		string
+	DigitsAfter		string
+	PaddingBefore		string
+	PaddingAfter		string
+}
 
-	defaults.NumWords = json_defaults.NumWords
-	defaults.WordLengthMin = json_defaults.WordLengthMin
-	defaults.WordLengthMax = json_defaults.WordLengthMax
-	json_defaults.CaseTransform = strings.ToLower(json_defaults.CaseTransform)
-	switch json_defaults.CaseTransform {
-	case "none":		defaults.CaseTransform = CaseNone
-	case "alternate":	defaults.CaseTransform = CaseAlternate
-	case "capitalise":	defaults.CaseTransform = CaseCapitalise
-	case "invert":		defaults.CaseTransform = CaseInvert
-	case "upper":		defaults.CaseTransform = CaseUpper
-	case "lower":		defaults.CaseTransform = CaseLower
-	case "random":		defaults.CaseTransform = CaseRandom
-	default:
-		return Defaults{}, errors.New(fmt.Sprintf("Error: Unknown CaseType: %v", json_defaults.CaseTransform))
-	}
-	defaults.SeparatorAlphabet = json_defaults.SeparatorAlphabet
-	json_defaults.SeparatorCharacter = strings.ToLower(json_defaults.SeparatorCharacter)
-	switch json_defaults.SeparatorCharacter {
-	case "none":	defaults.SeparatorCharacter = SeparatorNone
-	case "random":	defaults.SeparatorCharacter = SeparatorRandom
-	default:
-		if len(json_defaults.SeparatorCharacter) > 1 {
-			return Defaults{}, errors.New(fmt.Sprintf("Error: Unknown SeparatorCharacter: %v", json_defaults.SeparatorCharacter))
-		}
-		defaults.SeparatorCharacter = SeparatorCharacter
-		defaults.SeparatorAlphabet = make([]string, 1, 1)
-		defaults.SeparatorAlphabet[0] = json_defaults.SeparatorCharacter
-	}
-	defaults.PaddingDigitsBefore = json_defaults.PaddingDigitsBefore
-	defaults.PaddingDigitsAfter = json_defaults.PaddingDigitsAfter
-	json_defaults.PaddingType = strings.ToLower(json_defaults.PaddingType)
-	switch json_defaults.PaddingType {
-	case "none":		defaults.PaddingType = PaddingNone
-	case "fixed":		defaults.PaddingType = PaddingFixed
-	case "adaptive":	defaults.PaddingType = PaddingAdaptive
+type JSON_DryRun struct {
+	JSON_Defaults
+	DictionarySize		int		`json:"dictionary_size"`
+}
+
+type JSON_Password struct {
+	Password		string		`json:"password"`
+	Entropy			float64		`json:"entropy,omitempty"`
+}
+
+func parse_case_type(value string) (CaseType, error) {
+
+	switch strings.ToLower(value) {
+	case "none":		return CaseNone, nil
+	case "alternate":	return CaseAlternate, nil
+	case "capitalise":	return CaseCapitalise, nil
+	case "invert":		return CaseInvert, nil
+	case "upper":		return CaseUpper, nil
+	case "lower":		return CaseLower, nil
+	case "random":		return CaseRandom, nil
+	case "first":		return CaseFirstLetter, nil
+	case "word-random":	return CaseWordRandom, nil
+	case "title":		return CaseTitle, nil
+	case "syllable":	return CaseSyllable, nil
 	default:
-		return Defaults{}, errors.New(fmt.Sprintf("Error: Unknown PaddingType: %v", json_defaults.PaddingType))
+		return CaseNone, errors.New(fmt.Sprintf("Error: Unknown CaseType: %v", value))
 	}
-	defaults.SymbolAlphabet = json_defaults.SymbolAlphabet
-	json_defaults.PaddingCharacter = strings.ToLower(json_defaults.PaddingCharacter)
-	switch json_defaults.PaddingCharacter {
-	case "random":		defaults.PaddingCharacter = PaddingRandom
-	case "separator":	defaults.PaddingCharacter = PaddingSeparator
+}
+
+// Returns the separator type and the alphabet to pick separators from.  A
+// single character is treated as a fixed separator.
+func parse_separator_character(value string, alphabet []string) (SeparatorType, []string, error) {
+
+	switch strings.ToLower(value) {
+	case "none":	return SeparatorNone, alphabet, nil
+	case "random":	return SeparatorRandom, alphabet, nil
 	default:
-		if len(json_defaults.PaddingCharacter) > 1 {
-			return Defaults{}, errors.New(fmt.Sprintf("Error: Unknown PaddingCharacter: %v", json_defaults.PaddingCharacter))
+		// Counted in runes so that a multibyte character such as · is allowed
+		if utf8.RuneCountInString(value) > 1 {
+			return SeparatorNone, nil, errors.New(fmt.Sprintf("Error: Unknown SeparatorCharacter: %v", value))
 		}
-		defaults.PaddingCharacter = PaddingSpecified
-		defaults.SymbolAlphabet = make([]string, 1, 1)
-		defaults.SymbolAlphabet[0] = json_defaults.PaddingCharacter
+		return SeparatorCharacter, []string{ value }, nil
 	}
-	defaults.PaddingCharactersBefore = json_defaults.PaddingCharactersBefore
-	defaults.PaddingCharactersAfter = json_defaults.PaddingCharactersAfter
-	defaults.PadToLength = json_defaults.PadToLength
-
-	return defaults, err
 }
 
-func read_dictionary(filename string) ([]string, error) {
-
-	var dictionary []string
-	var err error
+func parse_digit_placement(value string) (DigitPlacement, error) {
 
-	content, err := ioutil.ReadFile(filename)
-	if err != nil {
-		log.Fatal("Error when opening file: ", err)
-		panic(err)
-	}
- 
-	err = json.Unmarshal(content, &dictionary)
-	if err != nil {
-		log.Fatal("Error during Unmarshal(): ", err)
-		panic(err)
+	switch strings.ToLower(value) {
+	case "", "both":	return DigitsBoth, nil
+	case "before":		return DigitsBefore, nil
+	case "after":		return DigitsAfter, nil
+	case "between-all":	return DigitsBetweenAll, nil
+	case "random-gap":	return DigitsRandomGap, nil
+	default:
+		return DigitsBoth, errors.New(fmt.Sprintf("Error: Unknown digit placement: %v", value))
 	}
-
-	return dictionary, err
-
 }
 
-func random_padding(defaults Defaults) string {
+// The reverse of parse_digit_placement
+func digit_placement_name(placement DigitPlacement) string {
 
-	var (
-		len_dictionary int64
-		n *big.Int
-		err error
-	)
+	switch placement {
+	case DigitsBefore:	return "before"
+	case DigitsAfter:	return "after"
+	case DigitsBetweenAll:	return "between-all"
+	case DigitsRandomGap:	return "random-gap"
+	default:		return "both"
+	}
+}
 
-	len_dictionary = int64(len(defaults.SymbolAlphabet))
+// Returns the least and most digits of the group before the first word,
+// the group after the last word and each group between words, as the
+// digit placement puts them.  Groups between words are sized like the
+// group after the last word.
+func digit_groups(defaults Defaults) (int, int, int, int, int, int) {
 
-	n, err = rand.Int(rand.Reader, big.NewInt(len_dictionary))
-	if err != nil {
-		log.Fatal("Error during rand.Int: ", err)
-		panic(err)
+	switch defaults.DigitPlacement {
+	case DigitsBefore:
+		return defaults.PaddingDigitsBefore, defaults.PaddingDigitsBeforeMax, 0, 0, 0, 0
+	case DigitsAfter:
+		return 0, 0, defaults.PaddingDigitsAfter, defaults.PaddingDigitsAfterMax, 0, 0
+	case DigitsBetweenAll, DigitsRandomGap:
+		return 0, 0, 0, 0, defaults.PaddingDigitsAfter, defaults.PaddingDigitsAfterMax
+	default:
+		return defaults.PaddingDigitsBefore, defaults.PaddingDigitsBeforeMax, defaults.PaddingDigitsAfter, defaults.PaddingDigitsAfterMax, 0, 0
 	}
+}
 
-	return defaults.SymbolAlphabet[int(n.Int64())]
+func parse_word_mode(value string) (WordMode, error) {
 
+	switch strings.ToLower(value) {
+	case "", "dictionary":	return WordsDictionary, nil
+	case "syllable":	return WordsSyllable, nil
+	default:
+		return WordsDictionary, errors.New(fmt.Sprintf("Error: Unknown mode: %v", value))
+	}
 }
 
-func random_separator(defaults Defaults) string {
+// The reverse of parse_word_mode
+func word_mode_name(mode WordMode) string {
 
-	var (
-		len_dictionary int64
-		n *big.Int
-		err error
-	)
+	switch mode {
+	case WordsSyllable:	return "syllable"
+	default:		return "dictionary"
+	}
+}
 
-	len_dictionary = int64(len(defaults.SeparatorAlphabet))
+func parse_word_selection(value string) (WordSelection, error) {
 
-	n, err = rand.Int(rand.Reader, big.NewInt(len_dictionary))
-	if err != nil {
-		log.Fatal("Error during rand.Int: ", err)
-		panic(err)
+	switch strings.ToLower(value) {
+	case "", "uniform":	return SelectUniform, nil
+	case "length":		return SelectByLength, nil
+	default:
+		return SelectUniform, errors.New(fmt.Sprintf("Error: Unknown word selection: %v", value))
 	}
+}
 
-	return defaults.SeparatorAlphabet[int(n.Int64())]
+// The reverse of parse_word_selection
+func word_selection_name(selection WordSelection) string {
 
+	switch selection {
+	case SelectByLength:	return "length"
+	default:		return "uniform"
+	}
 }
 
-func random_inner_word(defaults Defaults) string {
+// Parses length_weights, whose keys are word lengths
+func parse_length_weights(weights map[string]float64) (map[int]float64, error) {
 
 	var (
-		len_dictionary int64
-		n *big.Int
+		parsed map[int]float64
+		length int
 		err error
 	)
 
-	len_dictionary = int64(len(defaults.WordDictionary))
-
-	n, err = rand.Int(rand.Reader, big.NewInt(len_dictionary))
-	if err != nil {
-		log.Fatal("Error during rand.Int: ", err)
-		panic(err)
+	if len(weights) == 0 {
+		return nil, nil
 	}
 
-	return defaults.WordDictionary[int(n.Int64())]
+	parsed = make(map[int]float64, len(weights))
+	for key, weight := range weights {
+		length, err = strconv.Atoi(strings.TrimSpace(key))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error: length_weights has a length which is not a number: %v", key))
+		}
+		parsed[length] = weight
+	}
 
+	return parsed, nil
 }
 
-func random_word(defaults Defaults) string {
-
-	var word string
+func parse_padding_type(value string) (PaddingType, error) {
 
-	word = random_inner_word(defaults)
-	for len(word) < defaults.WordLengthMin || len(word) > defaults.WordLengthMax {
-		word = random_inner_word(defaults)
+	switch strings.ToLower(value) {
+	case "none":		return PaddingNone, nil
+	case "fixed":		return PaddingFixed, nil
+	case "adaptive":	return PaddingAdaptive, nil
+	case "multiple":	return PaddingMultiple, nil
+	default:
+		return PaddingNone, errors.New(fmt.Sprintf("Error: Unknown PaddingType: %v", value))
 	}
+}
 
-	switch defaults.CaseTransform {
-	case CaseLower:
-		word = strings.ToLower(word)
-	case CaseAlternate:
-		chars := []rune{}
-		for i, r := range word {
-//			if len(chars) == 0 || !unicode.IsLetter(chars[len(chars) - 1]) || unicode.IsLower(chars[len(chars) - 1]) {
-			if i % 2 == 0 {
-				chars = append(chars, unicode.ToUpper(r))
-			} else {
-				chars = append(chars, unicode.ToLower(r))
-			}
-		}
-		word = string(chars)
-	case CaseCapitalise:
-		chars := []rune{}
-		for i, r := range word {
-			if i == 0 {
-				chars = append(chars, unicode.ToUpper(r))
-			} else {
-				chars = append(chars, unicode.ToLower(r))
-			}
-		}
-		word = string(chars)
-	case CaseInvert:
-		chars := []rune{}
-		for i, r := range word {
-			if i == 0 {
-				chars = append(chars, unicode.ToLower(r))
-			} else {
-				chars = append(chars, unicode.ToUpper(r))
-			}
-		}
-		word = string(chars)
-	case CaseUpper:
-		word = strings.ToUpper(word)
-	case CaseRandom:
-		var (
-			n *big.Int
-			err error
-		)
-		chars := []rune{}
-		for _, r := range word {
-		word = string(chars)
-			n, err = rand.Int(rand.Reader, big.NewInt(2))
-			if err != nil {
-				log.Fatal("Error during rand.Int: ", err)
-				panic(err)
-			}
-			if n.Int64() == 0 {
-				chars = append(chars, unicode.ToLower(r))
-			} else {
-				chars = append(chars, unicode.ToUpper(r))
-			}
+// Returns the padding character type and the alphabet to pick padding from
+func parse_padding_character(value string, alphabet []string) (PaddingCharacter, []string, error) {
+
+	switch strings.ToLower(value) {
+	case "random":		return PaddingRandom, alphabet, nil
+	case "separator":	return PaddingSeparator, alphabet, nil
+	default:
+		if utf8.RuneCountInString(value) > 1 {
+			return PaddingRandom, nil, errors.New(fmt.Sprintf("Error: Unknown PaddingCharacter: %v", value))
 		}
-		word = string(chars)
+		return PaddingSpecified, []string{ value }, nil
 	}
-
-	return word
 }
 
-func random_digits(num_digits int) string {
-
-	var (
-		m int64
-		n *big.Int
-		err error
-	)
-
-	m = int64(math.Pow10(num_digits))
+// The reverse of parse_case_type
+func case_type_name(caseType CaseType) string {
 
-	n, err = rand.Int(rand.Reader, big.NewInt(m))
-	if err != nil {
-		log.Fatal("Error during rand.Int: ", err)
-		panic(err)
+	switch caseType {
+	case CaseNone:		return "none"
+	case CaseAlternate:	return "alternate"
+	case CaseCapitalise:	return "capitalise"
+	case CaseInvert:	return "invert"
+	case CaseUpper:		return "upper"
+	case CaseRandom:	return "random"
+	case CaseFirstLetter:	return "first"
+	case CaseWordRandom:	return "word-random"
+	case CaseTitle:		return "title"
+	case CaseSyllable:	return "syllable"
+	default:		return fmt.Sprintf("unknown (%d)", caseType)
 	}
+}
 
-	return fmt.Sprintf("%0*d", num_digits, n.Int64())
+// The reverse of parse_padding_type
+func padding_type_name(paddingType PaddingType) string {
 
+	switch paddingType {
+	case PaddingNone:	return "none"
+	case PaddingFixed:	return "fixed"
+	case PaddingAdaptive:	return "adaptive"
+	case PaddingMultiple:	return "multiple"
+	default:		return fmt.Sprintf("unknown (%d)", paddingType)
+	}
 }
 
-func generate_output(defaults Defaults) (error) {
-
-	var (
-		builder strings.Builder
-		result string
-		separator string
-		padding string
-		err error
-	)
+// The reverse of read_defaults, without the dictionary
+func to_json_defaults(defaults Defaults) JSON_Defaults {
 
-	separator = random_separator(defaults)
+	var json_defaults JSON_Defaults
 
-	if defaults.PaddingType == PaddingFixed || defaults.PaddingType == PaddingAdaptive {
-		if defaults.PaddingCharacter == PaddingRandom {
-			padding = random_padding(defaults)
-		} else if defaults.PaddingCharacter == PaddingSeparator {
-			padding = separator
-		} else if defaults.PaddingCharacter == PaddingSpecified {
-			padding = defaults.SymbolAlphabet[0]
-		}
+	json_defaults.NumWords = defaults.NumWords
+	json_defaults.WordLengthMin = defaults.WordLengthMin
+	json_defaults.WordLengthMax = defaults.WordLengthMax
+	json_defaults.CaseTransform = case_type_name(defaults.CaseTransform)
+	json_defaults.SeparatorAlphabet = defaults.SeparatorAlphabet
+	switch defaults.SeparatorCharacter {
+	case SeparatorNone:		json_defaults.SeparatorCharacter = "none"
+	case SeparatorRandom:		json_defaults.SeparatorCharacter = "random"
+	case SeparatorCharacter:	json_defaults.SeparatorCharacter = defaults.SeparatorAlphabet[0]
 	}
-
-	if defaults.PaddingType == PaddingFixed {
-		for i := 0; i < defaults.PaddingCharactersBefore; i++ {
-			fmt.Fprintf(&builder, "%v", padding)
-		}
+	json_defaults.SeparatorWeights = defaults.SeparatorWeights
+	json_defaults.PaddingDigitsBefore = defaults.PaddingDigitsBefore
+	json_defaults.PaddingDigitsAfter = defaults.PaddingDigitsAfter
+	json_defaults.PaddingDigitsBeforeMax = defaults.PaddingDigitsBeforeMax
+	json_defaults.PaddingDigitsAfterMax = defaults.PaddingDigitsAfterMax
+	json_defaults.PaddingType = padding_type_name(defaults.PaddingType)
+	json_defaults.SymbolAlphabet = defaults.SymbolAlphabet
+	switch defaults.PaddingCharacter {
+	case PaddingRandom:	json_defaults.PaddingCharacter = "random"
+	case PaddingSeparator:	json_defaults.PaddingCharacter = "separator"
+	case PaddingSpecified:	json_defaults.PaddingCharacter = defaults.SymbolAlphabet[0]
 	}
-
-	if defaults.PaddingDigitsBefore > 0 {
-		fmt.Fprintf(&builder, "%v", random_digits(defaults.PaddingDigitsBefore))
-		fmt.Fprintf(&builder, "%v", separator)
+	json_defaults.PaddingCharactersBefore = defaults.PaddingCharactersBefore
+	json_defaults.PaddingCharactersAfter = defaults.PaddingCharactersAfter
+	json_defaults.PaddingCharsBeforeMax = defaults.PaddingCharsBeforeMax
+	json_defaults.PaddingCharsAfterMax = defaults.PaddingCharsAfterMax
+	json_defaults.PadToLength = defaults.PadToLength
+	json_defaults.MaxIdenticalAdjacent = defaults.MaxIdenticalAdjacent
+	json_defaults.SeparatePaddingDigits = defaults.SeparatePaddingDigits
+	json_defaults.MinTotalLength = defaults.MinTotalLength
+	json_defaults.InjectSymbolProbability = defaults.InjectSymbolProbability
+	json_defaults.MaxTotalLength = defaults.MaxTotalLength
+	json_defaults.OnlyLetters = defaults.OnlyLetters
+	json_defaults.NoDuplicateWords = defaults.NoDuplicateWords
+	json_defaults.PadToMultiple = defaults.PadToMultiple
+	json_defaults.SeparatorSequence = defaults.SeparatorSequence
+	json_defaults.LeetProbability = defaults.LeetProbability
+	json_defaults.Mode = word_mode_name(defaults.Mode)
+	json_defaults.SyllablesPerWord = defaults.SyllablesPerWord
+	// Left out when it is the default of 0.5
+	if defaults.UppercaseRatio != 0.5 {
+		json_defaults.UppercaseRatio = &defaults.UppercaseRatio
 	}
-
-	for i := 0; i < defaults.NumWords; i++ {
-		fmt.Fprintf(&builder, "%v", random_word(defaults))
-		if i < defaults.NumWords - 1 {
-			fmt.Fprintf(&builder, "%v", separator)
+	json_defaults.DigitAlphabet = defaults.DigitAlphabet
+	json_defaults.AmbiguousCharacters = defaults.AmbiguousCharacters
+	json_defaults.DigitPlacement = digit_placement_name(defaults.DigitPlacement)
+	json_defaults.WordSelection = word_selection_name(defaults.WordSelection)
+	if len(defaults.LengthWeights) > 0 {
+		json_defaults.LengthWeights = make(map[string]float64, len(defaults.LengthWeights))
+		for length, weight := range defaults.LengthWeights {
+			json_defaults.LengthWeights[strconv.Itoa(length)] = weight
 		}
 	}
-
-	if defaults.PaddingDigitsAfter > 0 {
-		fmt.Fprintf(&builder, "%v", separator)
-		fmt.Fprintf(&builder, "%v", random_digits(defaults.PaddingDigitsAfter))
+	if defaults.MinWordVariety != defaultMinWordVariety {
+		json_defaults.MinWordVariety = &defaults.MinWordVariety
 	}
-
-	if defaults.PaddingType == PaddingFixed {
-		for i := 0; i < defaults.PaddingCharactersAfter; i++ {
-			fmt.Fprintf(&builder, "%v", padding)
+	if len(defaults.LeetMap) > 0 {
+		json_defaults.LeetMap = make(map[string]string, len(defaults.LeetMap))
+		for from, to := range defaults.LeetMap {
+			json_defaults.LeetMap[string(from)] = to
 		}
 	}
-
-	result = builder.String()
-	log.Printf("len builder = %v", len(result))
-
-	if defaults.PaddingType == PaddingAdaptive {
-		log.Printf("PadToLength = %v", defaults.PadToLength)
-		if len(result) > defaults.PadToLength {
-			result = result[1:defaults.PadToLength+1]
-		} else if len(result) < defaults.PadToLength {
-			var length = defaults.PadToLength - len(result)
-			for i := 0; i < length; i++ {
-				fmt.Fprintf(&builder, "%v", padding)
-			}
-			result = builder.String()
+	if len(defaults.HistogramLengths) > 0 {
+		json_defaults.LengthHistogram = make(map[string]float64, len(defaults.HistogramLengths))
+		for i, length := range defaults.HistogramLengths {
+			json_defaults.LengthHistogram[strconv.Itoa(length)] = defaults.HistogramWeights[i]
 		}
 	}
 
-	fmt.Printf("%v\n", result)
-
-	return err
+	return json_defaults
 }
 
-func main() {
-
-	var (
-		logMain *logrus.Logger = &logrus.Logger{
-			Out: os.Stderr,
-			Formatter: new(logrus.TextFormatter),
-			Level: logrus.DebugLevel,
-		}
-		ptrShouldVerson *bool
-		ptrShouldDebug *string
-		num_passwords = 1
-		args []string
-		out io.Writer
-		homeDir string
-		filename string
-		defaultFilename string
-		jsonData []byte
-		defaults Defaults
-		err error
-	)
-
-	ptrShouldVerson = flag.Bool("version", false, "Should output program version")
-	ptrShouldDebug = flag.String("shouldDebug", "false", "Should output debug output")
+// With strict set, unknown fields such as a misspelt num_word are errors
+// rather than being ignored
+func read_defaults(jsonData []byte, strict bool) (Defaults, error) {
 
-	flag.Parse()
-	args = flag.Args()
-
-	if *ptrShouldVerson {
-		fmt.Println("version =", version)
-		fmt.Println("release =", release)
-		os.Exit(0)
+	var json_defaults JSON_Defaults
+	var defaults Defaults
+	var decoder *json.Decoder
+	var err error
+	decoder = json.NewDecoder(bytes.NewReader(jsonData))
+	if strict {
+		decoder.DisallowUnknownFields()
 	}
-
-	switch strings.ToLower(*ptrShouldDebug) {
-	case "true":
-		shouldDebug = true
-	case "false":
-		shouldDebug = false
-	default:
-		logMain.Fatal(fmt.Sprintf("Error: shouldDebug is not true/false (%s)\n", *ptrShouldDebug))
+	err = decoder.Decode(&json_defaults)
+	if err != nil {
+		return Defaults{}, err
 	}
 
-	if shouldDebug {
-		out = os.Stderr
-	} else {
-		out = io.Discard
+	defaults.NumWords = json_defaults.NumWords
+	defaults.WordLengthMin = json_defaults.WordLengthMin
+	defaults.WordLengthMax = json_defaults.WordLengthMax
+	defaults.CaseTransform, err = parse_case_type(json_defaults.CaseTransform)
+	if err != nil {
+		return Defaults{}, err
 	}
-	log = &logrus.Logger{
-		Out: out,
-		Formatter: new(logrus.TextFormatter),
-		Level: logrus.DebugLevel,
+	defaults.SeparatorCharacter, defaults.SeparatorAlphabet, err = parse_separator_character(json_defaults.SeparatorCharacter, json_defaults.SeparatorAlphabet)
+	if err != nil {
+		return Defaults{}, err
 	}
-
-	log.Printf("flag.Args = %v\n", args)
-	if len(args) == 1 {
-		num_passwords, err = strconv.Atoi(args[0])
-		if err != nil {
-			logMain.Fatal("Error during strconv.Atoi: ", err)
-		}
-	} else if len(args) != 0 {
-		logMain.Fatal(fmt.Sprintf("Error: Only one argument is allowed\n"))
+	defaults.SeparatorWeights = json_defaults.SeparatorWeights
+	defaults.PaddingDigitsBefore = json_defaults.PaddingDigitsBefore
+	defaults.PaddingDigitsAfter = json_defaults.PaddingDigitsAfter
+	defaults.PaddingDigitsBeforeMax = json_defaults.PaddingDigitsBeforeMax
+	defaults.PaddingDigitsAfterMax = json_defaults.PaddingDigitsAfterMax
+	defaults.PaddingType, err = parse_padding_type(json_defaults.PaddingType)
+	if err != nil {
+		return Defaults{}, err
 	}
-
-	log.Printf("version = %v\nrelease = %v\n", version, release)
-
-	// Find home
-	homeDir, err = os.UserHomeDir()
+	defaults.PaddingCharacter, defaults.SymbolAlphabet, err = parse_padding_character(json_defaults.PaddingCharacter, json_defaults.SymbolAlphabet)
 	if err != nil {
-		logMain.Fatal("Error when callin os.UserHomeDir: ", err)
-		panic(err)
+		return Defaults{}, err
 	}
-	log.Printf("homeDir = %v", homeDir)
-
-	// Does our default file live at home?
-	filename = filepath.Join(homeDir, ".xkcd-defaults.json")
-	_, err = os.Stat(filename)
-	log.Printf("os.Stat(\"%v\") = %v\n", filename, err)
-	if err == nil {
-		defaultFilename = filename
-	} else {
-		// Or does it live in the current directory?
-		filename = ".xkcd-defaults.json"
-		_, err = os.Stat(filename)
-		log.Printf("os.Stat(\"%v\") = %v\n", filename, err)
-		if err == nil {
-			defaultFilename = filename
-		}
+	defaults.PaddingCharactersBefore = json_defaults.PaddingCharactersBefore
+	defaults.PaddingCharactersAfter = json_defaults.PaddingCharactersAfter
+	defaults.PaddingCharsBeforeMax = json_defaults.PaddingCharsBeforeMax
+	defaults.PaddingCharsAfterMax = json_defaults.PaddingCharsAfterMax
+	defaults.PadToLength = json_defaults.PadToLength
+	defaults.MaxIdenticalAdjacent = json_defaults.MaxIdenticalAdjacent
+	defaults.SeparatePaddingDigits = json_defaults.SeparatePaddingDigits
+	defaults.MinTotalLength = json_defaults.MinTotalLength
+	defaults.InjectSymbolProbability = json_defaults.InjectSymbolProbability
+	defaults.MaxTotalLength = json_defaults.MaxTotalLength
+	defaults.OnlyLetters = json_defaults.OnlyLetters
+	defaults.NoDuplicateWords = json_defaults.NoDuplicateWords
+	defaults.PadToMultiple = json_defaults.PadToMultiple
+	defaults.SeparatorSequence = json_defaults.SeparatorSequence
+	defaults.LeetProbability = json_defaults.LeetProbability
+	defaults.Mode, err = parse_word_mode(json_defaults.Mode)
+	if err != nil {
+		return Defaults{}, err
 	}
-
-	// Read the .xkcd-defaults.json file
-	jsonData, err = ioutil.ReadFile(defaultFilename)
+	defaults.SyllablesPerWord = json_defaults.SyllablesPerWord
+	defaults.UppercaseRatio = 0.5
+	if json_defaults.UppercaseRatio != nil {
+		defaults.UppercaseRatio = *json_defaults.UppercaseRatio
+	}
+	defaults.DigitAlphabet = json_defaults.DigitAlphabet
+	defaults.AmbiguousCharacters = json_defaults.AmbiguousCharacters
+	defaults.DigitPlacement, err = parse_digit_placement(json_defaults.DigitPlacement)
 	if err != nil {
-		logMain.Fatal("Error when opening .xkcd-defaults.json: ", err)
-		panic(err)
+		return Defaults{}, err
 	}
-
-	// Return the default struct from the file data
-	defaults, err = read_defaults(jsonData)
+	defaults.WordSelection, err = parse_word_selection(json_defaults.WordSelection)
+	if err != nil {
+		return Defaults{}, err
+	}
+	defaults.LengthWeights, err = parse_length_weights(json_defaults.LengthWeights)
 	if err != nil {
-		logMain.Fatal("Error reading defaults: ", err)
+		return Defaults{}, err
 	}
-	log.Printf("defaults: %+v\n", defaults)
-
-	log.Printf("len(dictionary) = %v\n", len(dictionary))
-	defaults.WordDictionary = dictionary
-
-	for i := 0; i < num_passwords; i++ {
-		// Generate the password based on the data in the defaults structure
-		err = generate_output(defaults)
-		if err != nil {
-			logMain.Fatal("Error generating output: ", err)
-			os.Exit(1)
+	defaults.MinWordVariety = defaultMinWordVariety
+	if json_defaults.MinWordVariety != nil {
+		defaults.MinWordVariety = *json_defaults.MinWordVariety
+	}
+	defaults.LeetMap, err = parse_leet_map(json_defaults.LeetMap)
+	if err != nil {
+		return Defaults{}, err
+	}
+	defaults.HistogramLengths, defaults.HistogramWeights, err = parse_length_histogram(json_defaults.LengthHistogram)
+	if err != nil {
+		return Defaults{}, err
+	}
+
+	err = validate_ranges(defaults)
+	if err != nil {
+		return Defaults{}, err
+	}
+	defaults.Log = discard_logger()
+	defaults.Random = rand.Reader
+
+	return defaults, err
+}
+
+// Drops every message, for defaults which run has not given its logger
+func discard_logger() *logrus.Logger {
+
+	return &logrus.Logger{
+		Out: io.Discard,
+		Formatter: plainFormatter{},
+		Level: logrus.PanicLevel,
+	}
+}
+
+// Checks that the numeric defaults are within sensible ranges
+func validate_ranges(defaults Defaults) error {
+
+	var errs []error
+
+	if defaults.NumWords < 1 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: num_words must be at least 1 (%d)", defaults.NumWords)))
+	}
+	if defaults.WordLengthMin < 1 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: word_length_min must be at least 1 (%d)", defaults.WordLengthMin)))
+	}
+	if defaults.WordLengthMin > defaults.WordLengthMax {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: word_length_min (%d) is greater than word_length_max (%d)", defaults.WordLengthMin, defaults.WordLengthMax)))
+	}
+	if defaults.PaddingDigitsBefore < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_digits_before must not be negative (%d)", defaults.PaddingDigitsBefore)))
+	}
+	if defaults.PaddingDigitsAfter < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_digits_after must not be negative (%d)", defaults.PaddingDigitsAfter)))
+	}
+	if defaults.PaddingDigitsBeforeMax != 0 && defaults.PaddingDigitsBeforeMax < defaults.PaddingDigitsBefore {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_digits_before_max (%d) is less than padding_digits_before (%d)", defaults.PaddingDigitsBeforeMax, defaults.PaddingDigitsBefore)))
+	}
+	if defaults.PaddingDigitsAfterMax != 0 && defaults.PaddingDigitsAfterMax < defaults.PaddingDigitsAfter {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_digits_after_max (%d) is less than padding_digits_after (%d)", defaults.PaddingDigitsAfterMax, defaults.PaddingDigitsAfter)))
+	}
+	if defaults.PaddingCharactersBefore < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_characters_before must not be negative (%d)", defaults.PaddingCharactersBefore)))
+	}
+	if defaults.PaddingCharactersAfter < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_characters_after must not be negative (%d)", defaults.PaddingCharactersAfter)))
+	}
+	if defaults.PaddingCharsBeforeMax != 0 && defaults.PaddingCharsBeforeMax < defaults.PaddingCharactersBefore {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_characters_before_max (%d) is less than padding_characters_before (%d)", defaults.PaddingCharsBeforeMax, defaults.PaddingCharactersBefore)))
+	}
+	if defaults.PaddingCharsAfterMax != 0 && defaults.PaddingCharsAfterMax < defaults.PaddingCharactersAfter {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: padding_characters_after_max (%d) is less than padding_characters_after (%d)", defaults.PaddingCharsAfterMax, defaults.PaddingCharactersAfter)))
+	}
+	if defaults.MinTotalLength != 0 || defaults.MaxTotalLength != 0 {
+		if defaults.MinTotalLength < 1 {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: min_total_length must be at least 1 (%d)", defaults.MinTotalLength)))
+		}
+		if defaults.MinTotalLength > defaults.MaxTotalLength {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: min_total_length (%d) is greater than max_total_length (%d)", defaults.MinTotalLength, defaults.MaxTotalLength)))
+		}
+		if len(defaults.HistogramLengths) > 0 {
+			errs = append(errs, errors.New("Error: length_histogram cannot be used with min_total_length and max_total_length"))
+		}
+	} else if len(defaults.HistogramLengths) > 0 {
+		var total float64 = 0
+		for i, length := range defaults.HistogramLengths {
+			if length < 1 {
+				errs = append(errs, errors.New(fmt.Sprintf("Error: length_histogram lengths must be at least 1 (%d)", length)))
+			}
+			if defaults.HistogramWeights[i] < 0 {
+				errs = append(errs, errors.New(fmt.Sprintf("Error: length_histogram weights must not be negative (%v)", defaults.HistogramWeights[i])))
+			}
+			total += defaults.HistogramWeights[i]
+		}
+		if total <= 0 {
+			errs = append(errs, errors.New("Error: length_histogram needs at least one positive weight"))
+		}
+	} else if defaults.PaddingType == PaddingAdaptive && defaults.PadToLength < 1 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: pad_to_length must be at least 1 for adaptive padding (%d)", defaults.PadToLength)))
+	}
+	if defaults.MaxIdenticalAdjacent < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: max_identical_adjacent must not be negative (%d)", defaults.MaxIdenticalAdjacent)))
+	}
+	if defaults.Mode == WordsSyllable && (defaults.SyllablesPerWord < 1 || defaults.SyllablesPerWord > 8) {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: syllables_per_word must be between 1 and 8 for syllable mode (%d)", defaults.SyllablesPerWord)))
+	}
+	if (defaults.DigitPlacement == DigitsBetweenAll || defaults.DigitPlacement == DigitsRandomGap) && defaults.NumWords < 2 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: digit_placement %v needs at least two words (%d)", digit_placement_name(defaults.DigitPlacement), defaults.NumWords)))
+	}
+	for length, weight := range defaults.LengthWeights {
+		if length < 1 {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: length_weights lengths must be at least 1 (%d)", length)))
+		}
+		if weight < 0 {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: length_weights weights must not be negative (%v)", weight)))
+		}
+	}
+	if defaults.MinWordVariety < 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: min_word_variety must not be negative (%d)", defaults.MinWordVariety)))
+	}
+	if defaults.UppercaseRatio < 0 || defaults.UppercaseRatio > 1 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: uppercase_ratio must be between 0 and 1 (%v)", defaults.UppercaseRatio)))
+	}
+	if defaults.LeetProbability < 0 || defaults.LeetProbability > 1 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: leet_probability must be between 0 and 1 (%v)", defaults.LeetProbability)))
+	}
+	if defaults.PaddingType == PaddingMultiple && defaults.PadToMultiple < 1 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: pad_to_multiple must be at least 1 for multiple padding (%d)", defaults.PadToMultiple)))
+	}
+	// Counted in runes so that a multibyte character such as £ is allowed
+	for _, entry := range defaults.SeparatorAlphabet {
+		if utf8.RuneCountInString(entry) != 1 {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: separator_alphabet entries must be a single character (%q)", entry)))
+		}
+	}
+	for _, entry := range defaults.SymbolAlphabet {
+		if utf8.RuneCountInString(entry) != 1 {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: symbol_alphabet entries must be a single character (%q)", entry)))
+		}
+	}
+	// Only an explicitly empty digit_alphabet is left non nil
+	if defaults.DigitAlphabet != nil && len(defaults.DigitAlphabet) == 0 {
+		errs = append(errs, errors.New("Error: digit_alphabet must not be empty"))
+	}
+	for _, entry := range defaults.DigitAlphabet {
+		if utf8.RuneCountInString(entry) != 1 {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: digit_alphabet entries must be a single character (%q)", entry)))
+		}
+	}
+	for _, entry := range defaults.SeparatorSequence {
+		if utf8.RuneCountInString(entry) != 1 {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: separator_sequence entries must be a single character (%q)", entry)))
+		}
+	}
+	if defaults.InjectSymbolProbability < 0 || defaults.InjectSymbolProbability > 0.5 {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: inject_symbol_probability must be between 0 and 0.5 (%v)", defaults.InjectSymbolProbability)))
+	}
+	if defaults.SeparatorCharacter == SeparatorRandom && len(defaults.SeparatorWeights) > 0 {
+		var total float64 = 0
+		if len(defaults.SeparatorWeights) != len(defaults.SeparatorAlphabet) {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: separator_weights has %d entries but separator_alphabet has %d", len(defaults.SeparatorWeights), len(defaults.SeparatorAlphabet))))
+		}
+		for _, weight := range defaults.SeparatorWeights {
+			if weight < 0 {
+				errs = append(errs, errors.New(fmt.Sprintf("Error: separator_weights must not be negative (%v)", weight)))
+			}
+			total += weight
+		}
+		if total <= 0 {
+			errs = append(errs, errors.New("Error: separator_weights must not all be zero"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Checks that the defaults can actually be used to generate a password,
+// returning every problem found rather than just the first.
+func validate_defaults(defaults Defaults) error {
+
+	var (
+		errs []error
+		found bool = false
+		err error
+	)
+
+	err = validate_ranges(defaults)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if defaults.SeparatorCharacter == SeparatorRandom && len(defaults.SeparatorAlphabet) == 0 {
+		errs = append(errs, errors.New("Error: separator_character is random but separator_alphabet is empty"))
+	}
+	if len(defaults.HistogramLengths) > 0 && defaults.PaddingType != PaddingAdaptive {
+		errs = append(errs, errors.New("Error: length_histogram needs adaptive padding"))
+	}
+	if len(defaults.LengthWeights) > 0 && defaults.WordSelection != SelectByLength {
+		errs = append(errs, errors.New("Error: length_weights needs word_selection length"))
+	}
+	if defaults.InjectSymbolProbability > 0 && len(defaults.SymbolAlphabet) == 0 {
+		errs = append(errs, errors.New("Error: inject_symbol_probability is set but symbol_alphabet is empty"))
+	}
+	if defaults.PaddingType != PaddingNone && defaults.PaddingCharacter == PaddingRandom && len(defaults.SymbolAlphabet) == 0 {
+		errs = append(errs, errors.New("Error: padding_character is random but symbol_alphabet is empty"))
+	}
+	// Truncating any shorter could cut into or remove the last words
+	if defaults.PaddingType == PaddingAdaptive && defaults.Format == nil && shortest_target_length(defaults) < maximum_words_length(defaults) {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: Adaptive padding can truncate to %d characters but the words can need %d; lengthen pad_to_length or use fewer or shorter words", shortest_target_length(defaults), maximum_words_length(defaults))))
+	}
+	// Syllable mode does not use the dictionary
+	if defaults.Mode == WordsSyllable {
+		return errors.Join(errs...)
+	}
+	for _, word := range defaults.WordDictionary {
+		if defaults.OnlyLetters {
+			word = only_letters(word)
+		}
+		if len(word) >= defaults.WordLengthMin && len(word) <= defaults.WordLengthMax {
+			found = true
+			break
+		}
+	}
+	if !found && defaults.WordLengthMin <= defaults.WordLengthMax {
+		errs = append(errs, errors.New(fmt.Sprintf("Error: No dictionary words are between %d and %d characters long", defaults.WordLengthMin, defaults.WordLengthMax)))
+	}
+	if found && defaults.NoDuplicateWords {
+		var distinct = map[string]bool{}
+		for _, word := range candidate_words(defaults) {
+			distinct[strings.ToLower(word)] = true
+		}
+		if len(distinct) < defaults.NumWords {
+			errs = append(errs, errors.New(fmt.Sprintf("Error: no_duplicate_words needs %d different words but the dictionary only has %d between %d and %d characters long", defaults.NumWords, len(distinct), defaults.WordLengthMin, defaults.WordLengthMax)))
+		}
+	}
+	if found && defaults.WordSelection == SelectByLength {
+		var _, weights = word_buckets(defaults, candidate_words(defaults))
+		var total float64 = 0
+		for _, weight := range weights {
+			total += weight
+		}
+		if total <= 0 {
+			errs = append(errs, errors.New("Error: length_weights gives every word length within the bounds a weight of 0"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns a description of each padding setting which pads nothing,
+// naming the fields involved.  Adaptive padding with a pad_to_length of 0
+// is already an error in validate_ranges.
+func degenerate_padding(defaults Defaults) []string {
+
+	var problems []string
+
+	switch defaults.PaddingType {
+	case PaddingFixed:
+		if defaults.PaddingCharactersBefore == 0 && defaults.PaddingCharactersAfter == 0 && defaults.PaddingCharsBeforeMax == 0 && defaults.PaddingCharsAfterMax == 0 {
+			problems = append(problems, "padding_type is fixed but padding_characters_before and padding_characters_after are both 0, so nothing is padded")
+		}
+	case PaddingMultiple:
+		if defaults.PadToMultiple == 1 {
+			problems = append(problems, "padding_type is multiple but pad_to_multiple is 1, so nothing is padded")
+		}
+	}
+
+	return problems
+}
+
+// Reports whether nothing marks where one word ends and the next begins,
+// so that the words run on into a single blob like "correcthorsebattery"
+func runs_on(defaults Defaults) bool {
+
+	if defaults.NumWords < 2 || defaults.Format != nil {
+		return false
+	}
+	if defaults.SeparatorCharacter != SeparatorNone || len(defaults.SeparatorSequence) > 0 {
+		return false
+	}
+	if defaults.DigitPlacement == DigitsBetweenAll {
+		return false
+	}
+
+	// Capitalise, invert, first and syllable change case at the start of
+	// each word.  Title only sees one long word without a separator, and
+	// alternate, random and word-random often carry on in the same case.
+	switch defaults.CaseTransform {
+	case CaseNone, CaseUpper, CaseAlternate, CaseRandom, CaseWordRandom, CaseTitle:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the word length at the given percentile (0 to 100) of the
+// dictionary using the nearest-rank method
+func length_percentile(dictionary []string, percentile float64) int {
+
+	var (
+		lengths []int
+		rank int
+	)
+
+	if len(dictionary) == 0 {
+		return 0
+	}
+
+	lengths = make([]int, 0, len(dictionary))
+	for _, word := range dictionary {
+		lengths = append(lengths, len(word))
+	}
+	sort.Ints(lengths)
+
+	rank = int(math.Ceil(percentile / 100 * float64(len(lengths))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return lengths[rank - 1]
+}
+
+// Splits a plain text word list into words, one per line, skipping blank
+// lines and lines beginning with #
+func parse_text_dictionary(content string) []string {
+
+	var dictionary []string
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dictionary = append(dictionary, line)
+	}
+
+	return dictionary
+}
+
+func read_dictionary(filename string) ([]string, error) {
+
+	var dictionary []string
+	var err error
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// A JSON array of strings, otherwise one word per line
+	if strings.HasPrefix(strings.TrimSpace(string(content)), "[") {
+		err = json.Unmarshal(content, &dictionary)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error: Could not parse the dictionary %v: %v", filename, err))
+		}
+	} else {
+		dictionary = parse_text_dictionary(string(content))
+	}
+
+	if len(dictionary) == 0 {
+		return nil, errors.New(fmt.Sprintf("Error: The dictionary %v is empty", filename))
+	}
+
+	return dictionary, err
+
+}
+
+// Runs the command with the shell, giving it the password on stdin so that
+// it never appears in a process list.  Any {} in the command is replaced by
+// /dev/stdin for commands which only read passwords from a file.
+func run_hook(command string, password string, out io.Writer) error {
+
+	var (
+		cmd *exec.Cmd
+		exitErr *exec.ExitError
+		err error
+	)
+
+	cmd = exec.Command("sh", "-c", strings.ReplaceAll(command, "{}", "/dev/stdin"))
+	cmd.Stdin = strings.NewReader(password + "\n")
+	// Keep stdout for the passwords themselves
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err = cmd.Run()
+	if errors.As(err, &exitErr) {
+		return errors.New(fmt.Sprintf("Error: on-generate command exited with status %d", exitErr.ExitCode()))
+	}
+
+	return err
+}
+
+// Returns the words which match the regular expression
+func filter_words_by_regex(dictionary []string, pattern string) ([]string, error) {
+
+	var (
+		re *regexp.Regexp
+		result []string
+		err error
+	)
+
+	re, err = regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error: Invalid word-regex %q: %v", pattern, err))
+	}
+
+	result = make([]string, 0, len(dictionary))
+	for _, word := range dictionary {
+		if re.MatchString(word) {
+			result = append(result, word)
+		}
+	}
+
+	return result, nil
+}
+
+// Reads the word list from the file, or from the cache after fetching it
+// when it is a URL
+func load_dictionary(name string, log *logrus.Logger) ([]string, error) {
+
+	var (
+		filename string = name
+		cacheDir string
+		err error
+	)
+
+	if is_url(name) {
+		cacheDir, err = dictionary_cache_dir()
+		if err != nil {
+			return nil, err
+		}
+		filename, err = fetch_dictionary(name, cacheDir, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return read_dictionary(filename)
+}
+
+// Merges the word lists, keeping the order in which words first appear:
+//   union     - every word once
+//   intersect - every word found in all of the lists, once
+//   concat    - every word from every list, so words in several lists are
+//               more likely to be picked
+func merge_dictionaries(lists [][]string, strategy string) ([]string, error) {
+
+	var (
+		result []string
+		seen map[string]bool = map[string]bool{}
+	)
+
+	switch strategy {
+	case "union":
+		for _, list := range lists {
+			for _, word := range list {
+				if !seen[word] {
+					seen[word] = true
+					result = append(result, word)
+				}
+			}
+		}
+	case "intersect":
+		var counts map[string]int = map[string]int{}
+		for _, list := range lists {
+			var inList = map[string]bool{}
+			for _, word := range list {
+				if !inList[word] {
+					inList[word] = true
+					counts[word]++
+				}
+			}
+		}
+		if len(lists) > 0 {
+			for _, word := range lists[0] {
+				if counts[word] == len(lists) && !seen[word] {
+					seen[word] = true
+					result = append(result, word)
+				}
+			}
+		}
+	case "concat":
+		for _, list := range lists {
+			result = append(result, list...)
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Error: Unknown merge strategy %v (union, intersect, concat)", strategy))
+	}
+
+	return result, nil
+}
+
+// Returns the first of the word lists with a word within the length
+// bounds, and its index, so that a later list only stands in for the ones
+// before it when the bounds leave nothing of them.  The index is -1 when
+// none of them has such a word.
+func prioritise_dictionaries(defaults Defaults, lists [][]string) ([]string, int) {
+
+	for i, list := range lists {
+		defaults.WordDictionary = list
+		if len(candidate_words(defaults)) > 0 {
+			return list, i
+		}
+	}
+
+	return nil, -1
+}
+
+// Returns a uniformly distributed integer in [0, bound) drawn from random
+func random_int(random io.Reader, bound int64) (int64, error) {
+
+	var (
+		n *big.Int
+		err error
+	)
+
+	n, err = rand.Int(random, big.NewInt(bound))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error during rand.Int: %v", err))
+	}
+
+	return n.Int64(), nil
+
+}
+
+func random_padding(defaults Defaults) (string, error) {
+
+	var (
+		len_dictionary int64
+		n int64
+		err error
+	)
+
+	len_dictionary = int64(len(defaults.SymbolAlphabet))
+
+	// rand.Int panics with a zero bound
+	if len_dictionary == 0 {
+		return "", nil
+	}
+
+	n, err = random_int(defaults.Random, len_dictionary)
+	if err != nil {
+		return "", err
+	}
+
+	return defaults.SymbolAlphabet[int(n)], nil
+
+}
+
+// Describes where the random numbers come from, for audits
+func entropy_source_info(random io.Reader) string {
+
+	if _, seeded := random.(*SeededReader); seeded {
+		return "entropy source: -seed (deterministic SHA-256 counter stream, NOT cryptographically secure)"
+	}
+
+	return fmt.Sprintf("entropy source: crypto/rand (operating system CSPRNG on %v/%v)", runtime.GOOS, runtime.GOARCH)
+
+}
+
+// Returns a uniformly distributed integer in [min, max]
+func random_between(random io.Reader, min int, max int) (int, error) {
+
+	var (
+		n int64
+		err error
+	)
+
+	n, err = random_int(random, int64(max - min + 1))
+	if err != nil {
+		return 0, err
+	}
+
+	return min + int(n), nil
+
+}
+
+// Returns min when there is no max, otherwise a count in [min, max]
+func random_count(random io.Reader, min int, max int) (int, error) {
+
+	if max <= min {
+		return min, nil
+	}
+
+	return random_between(random, min, max)
+
+}
+
+// Returns a uniformly distributed float in [0, 1)
+func random_float(random io.Reader) (float64, error) {
+
+	var (
+		n int64
+		err error
+	)
+
+	// A float64 has 53 bits of mantissa
+	n, err = random_int(random, 1 << 53)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(n) / float64(1 << 53), nil
+
+}
+
+// Returns an index into the weights, picked in proportion to its weight
+func random_weighted_index(random io.Reader, weights []float64) (int, error) {
+
+	var (
+		total float64 = 0
+		target float64
+		cumulative float64 = 0
+		err error
+	)
+
+	for _, weight := range weights {
+		total += weight
+	}
+
+	target, err = random_float(random)
+	if err != nil {
+		return 0, err
+	}
+	target *= total
+	for i, weight := range weights {
+		cumulative += weight
+		if target < cumulative {
+			return i, nil
+		}
+	}
+
+	// Only reachable through rounding, so use the last non-zero weight
+	for i := len(weights) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return i, nil
+		}
+	}
+
+	return len(weights) - 1, nil
+
+}
+
+func random_weighted_separator(defaults Defaults) (string, error) {
+
+	var (
+		i int
+		err error
+	)
+
+	i, err = random_weighted_index(defaults.Random, defaults.SeparatorWeights)
+	if err != nil {
+		return "", err
+	}
+
+	return defaults.SeparatorAlphabet[i], nil
+
+}
+
+func random_separator(defaults Defaults) (string, error) {
+
+	var (
+		len_dictionary int64
+		n int64
+		err error
+	)
+
+	if defaults.SeparatorCharacter == SeparatorNone {
+		return "", nil
+	}
+	if defaults.SeparatorCharacter == SeparatorRandom && len(defaults.SeparatorWeights) > 0 {
+		return random_weighted_separator(defaults)
+	}
+
+	len_dictionary = int64(len(defaults.SeparatorAlphabet))
+
+	// rand.Int panics with a zero bound
+	if len_dictionary == 0 {
+		return "", nil
+	}
+
+	n, err = random_int(defaults.Random, len_dictionary)
+	if err != nil {
+		return "", err
+	}
+
+	return defaults.SeparatorAlphabet[int(n)], nil
+
+}
+
+func random_inner_word(defaults Defaults) (string, error) {
+
+	var (
+		len_dictionary int64
+		n int64
+		err error
+	)
+
+	len_dictionary = int64(len(defaults.WordDictionary))
+
+	n, err = random_int(defaults.Random, len_dictionary)
+	if err != nil {
+		return "", err
+	}
+
+	return defaults.WordDictionary[int(n)], nil
+
+}
+
+// Returns the dictionary words within the length bounds, stripped when
+// OnlyLetters is set, so that random_word can pick one with a single draw
+func candidate_words(defaults Defaults) []string {
+
+	var candidates []string
+
+	if defaults.Mode == WordsSyllable {
+		return nil
+	}
+
+	candidates = make([]string, 0, len(defaults.WordDictionary))
+	for _, word := range defaults.WordDictionary {
+		if defaults.OnlyLetters {
+			word = only_letters(word)
+		}
+		if len(word) >= defaults.WordLengthMin && len(word) <= defaults.WordLengthMax {
+			candidates = append(candidates, word)
+		}
+	}
+
+	return candidates
+}
+
+// Groups the candidates by length, shortest first, with the weight of each
+// length: its length_weights entry, or 1 when it has none
+func word_buckets(defaults Defaults, candidates []string) ([][]string, []float64) {
+
+	var (
+		byLength map[int][]string = make(map[int][]string)
+		lengths []int
+		buckets [][]string
+		weights []float64
+	)
+
+	for _, word := range candidates {
+		if _, found := byLength[len(word)]; !found {
+			lengths = append(lengths, len(word))
+		}
+		byLength[len(word)] = append(byLength[len(word)], word)
+	}
+	sort.Ints(lengths)
+
+	for _, length := range lengths {
+		var weight float64 = 1
+		if configured, found := defaults.LengthWeights[length]; found {
+			weight = configured
+		}
+		buckets = append(buckets, byLength[length])
+		weights = append(weights, weight)
+	}
+
+	return buckets, weights
+}
+
+func random_word(defaults Defaults) (string, error) {
+
+	var (
+		word string
+		n int64
+		err error
+	)
+
+	if defaults.Mode == WordsSyllable {
+		word, err = random_syllable_word(defaults.Random, defaults.SyllablesPerWord)
+		if err != nil {
+			return "", err
+		}
+		word, err = transform_word_case(defaults, word)
+		if err != nil {
+			return "", err
+		}
+		return leet(defaults, word)
+	}
+
+	// Each length is as likely as its weight, however many words have it
+	if len(defaults.WordBuckets) > 0 {
+		var i int
+		i, err = random_weighted_index(defaults.Random, defaults.BucketWeights)
+		if err != nil {
+			return "", err
+		}
+		n, err = random_int(defaults.Random, int64(len(defaults.WordBuckets[i])))
+		if err != nil {
+			return "", err
+		}
+		word, err = transform_word_case(defaults, defaults.WordBuckets[i][n])
+		if err != nil {
+			return "", err
+		}
+		return leet(defaults, word)
+	}
+
+	// Drawing from the candidates picks each qualifying dictionary entry
+	// with the same probability as the rejection sampling below
+	if len(defaults.WordCandidates) > 0 {
+		n, err = random_int(defaults.Random, int64(len(defaults.WordCandidates)))
+		if err != nil {
+			return "", err
+		}
+		word, err = transform_word_case(defaults, defaults.WordCandidates[n])
+		if err != nil {
+			return "", err
+		}
+		return leet(defaults, word)
+	}
+
+	// Stripping happens before the length check so the bounds hold for the
+	// word as it appears in the password
+	word, err = random_inner_word(defaults)
+	if defaults.OnlyLetters {
+		word = only_letters(word)
+	}
+	for err == nil && (len(word) < defaults.WordLengthMin || len(word) > defaults.WordLengthMax) {
+		word, err = random_inner_word(defaults)
+		if defaults.OnlyLetters {
+			word = only_letters(word)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	word, err = transform_word_case(defaults, word)
+	if err != nil {
+		return "", err
+	}
+
+	return leet(defaults, word)
+}
+
+// Returns a pronounceable word of consonant-vowel syllables, like "tavomi"
+func random_syllable_word(random io.Reader, syllables int) (string, error) {
+
+	var (
+		builder strings.Builder
+		n int64
+		err error
+	)
+
+	for i := 0; i < syllables; i++ {
+		n, err = random_int(random, int64(len(syllableConsonants)))
+		if err != nil {
+			return "", err
+		}
+		builder.WriteByte(syllableConsonants[n])
+		n, err = random_int(random, int64(len(syllableVowels)))
+		if err != nil {
+			return "", err
+		}
+		builder.WriteByte(syllableVowels[n])
+	}
+
+	return builder.String(), nil
+}
+
+// Returns the substitutions leet uses
+func leet_map(defaults Defaults) map[rune]string {
+
+	if len(defaults.LeetMap) > 0 {
+		return defaults.LeetMap
+	}
+
+	return defaultLeetMap
+}
+
+// Substitutes each letter of the word which has a leet substitution, in
+// either case, with probability leet_probability, after any case change
+func leet(defaults Defaults, word string) (string, error) {
+
+	var (
+		substitutions map[rune]string
+		builder strings.Builder
+		draw float64
+		err error
+	)
+
+	if defaults.LeetProbability <= 0 {
+		return word, nil
+	}
+
+	substitutions = leet_map(defaults)
+	for _, r := range word {
+		to, found := substitutions[unicode.ToLower(r)]
+		if found {
+			draw, err = random_float(defaults.Random)
+			if err != nil {
+				return "", err
+			}
+			if draw < defaults.LeetProbability {
+				builder.WriteString(to)
+				continue
+			}
+		}
+		builder.WriteRune(r)
+	}
+
+	return builder.String(), nil
+}
+
+// Converts a leet_map from the defaults file, whose keys must be single
+// characters, keyed by lowercase rune
+func parse_leet_map(value map[string]string) (map[rune]string, error) {
+
+	var result map[rune]string
+
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	result = make(map[rune]string, len(value))
+	for from, to := range value {
+		if utf8.RuneCountInString(from) != 1 {
+			return nil, errors.New(fmt.Sprintf("Error: leet_map keys must be a single character (%q)", from))
+		}
+		r, _ := utf8.DecodeRuneInString(from)
+		result[unicode.ToLower(r)] = to
+	}
+
+	return result, nil
+}
+
+// Returns the word with every rune which is not a letter removed
+func only_letters(word string) string {
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return r
+		}
+		return -1
+	}, word)
+}
+
+// Like transform_case, but CaseRandom uppercases each character with
+// probability uppercase_ratio
+func transform_word_case(defaults Defaults, word string) (string, error) {
+
+	if defaults.CaseTransform == CaseRandom {
+		return random_case(defaults.Random, word, defaults.UppercaseRatio)
+	}
+
+	return transform_case(defaults.Random, word, defaults.CaseTransform)
+}
+
+// Uppercases each character with probability ratio and lowercases the rest
+func random_case(random io.Reader, word string, ratio float64) (string, error) {
+
+	var (
+		chars []rune
+		draw float64
+		err error
+	)
+
+	chars = make([]rune, 0, len(word))
+	for _, r := range word {
+		draw, err = random_float(random)
+		if err != nil {
+			return "", err
+		}
+		if draw < ratio {
+			chars = append(chars, unicode.ToUpper(r))
+		} else {
+			chars = append(chars, unicode.ToLower(r))
+		}
+	}
+
+	return string(chars), nil
+}
+
+func transform_case(random io.Reader, word string, caseTransform CaseType) (string, error) {
+
+	switch caseTransform {
+	case CaseLower:
+		word = strings.ToLower(word)
+	case CaseAlternate:
+		// Only letters count, so "o'neil" becomes "O'nEiL" rather than
+		// "O'NeIl", and the apostrophe is left as it is
+		chars := []rune{}
+		letters := 0
+		for _, r := range word {
+			if !unicode.IsLetter(r) {
+				chars = append(chars, r)
+				continue
+			}
+			if letters % 2 == 0 {
+				chars = append(chars, unicode.ToUpper(r))
+			} else {
+				chars = append(chars, unicode.ToLower(r))
+			}
+			letters++
+		}
+		word = string(chars)
+	case CaseCapitalise:
+		chars := []rune{}
+		for i, r := range word {
+			if i == 0 {
+				chars = append(chars, unicode.ToUpper(r))
+			} else {
+				chars = append(chars, unicode.ToLower(r))
+			}
+		}
+		word = string(chars)
+	case CaseInvert:
+		chars := []rune{}
+		for i, r := range word {
+			if i == 0 {
+				chars = append(chars, unicode.ToLower(r))
+			} else {
+				chars = append(chars, unicode.ToUpper(r))
+			}
+		}
+		word = string(chars)
+	case CaseFirstLetter:
+		chars := []rune{}
+		for i, r := range word {
+			if i == 0 {
+				chars = append(chars, unicode.ToUpper(r))
+			} else {
+				chars = append(chars, r)
+			}
+		}
+		word = string(chars)
+	case CaseUpper:
+		word = strings.ToUpper(word)
+	case CaseRandom:
+		return random_case(random, word, 0.5)
+	case CaseSyllable:
+		word = syllable_case(word)
+	case CaseWordRandom:
+		var (
+			n int64
+			err error
+		)
+		n, err = random_int(random, 2)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			return transform_case(random, word, CaseLower)
+		} else {
+			return transform_case(random, word, CaseCapitalise)
+		}
+	}
+
+	return word, nil
+}
+
+func is_vowel(r rune, first bool) bool {
+
+	switch unicode.ToLower(r) {
+	case 'a', 'e', 'i', 'o', 'u':
+		return true
+	case 'y':
+		// A leading y is a consonant, as in yellow
+		return !first
+	}
+
+	return false
+}
+
+// Uppercases the first letter of every syllable in the word and lowercases
+// the rest.  Syllables are guessed: each group of vowels is one syllable,
+// except a final e after a consonant, which is silent.  A syllable starts
+// at the consonant just before its vowels, so "battery" becomes "BatTeRy"
+// and "horse" stays one syllable, "Horse".  Expect misses like "ReaDy".
+func syllable_case(word string) string {
+
+	var (
+		chars []rune = []rune(strings.ToLower(word))
+		vowels []bool
+		starts []bool
+	)
+
+	vowels = make([]bool, len(chars))
+	for i, r := range chars {
+		vowels[i] = unicode.IsLetter(r) && is_vowel(r, i == 0)
+	}
+	if len(chars) > 1 && chars[len(chars) - 1] == 'e' && !vowels[len(chars) - 2] {
+		vowels[len(chars) - 1] = false
+	}
+
+	starts = make([]bool, len(chars))
+	if len(chars) > 0 {
+		starts[0] = true
+	}
+	for i := 1; i < len(chars); i++ {
+		// The start of a later vowel group, with a consonant before it
+		if vowels[i] && !vowels[i - 1] && i - 1 > 0 && unicode.IsLetter(chars[i - 1]) {
+			for j := 0; j < i - 1; j++ {
+				if vowels[j] {
+					starts[i - 1] = true
+					break
+				}
+			}
+		}
+	}
+
+	for i := range chars {
+		if starts[i] {
+			chars[i] = unicode.ToUpper(chars[i])
+		}
+	}
+
+	return string(chars)
+}
+
+// Returns 10^num_digits, the bound for drawing that many decimal digits
+func digits_bound(num_digits int) *big.Int {
+
+	// 10^num_digits overflows an int64 beyond 18 digits
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(num_digits)), nil)
+}
+
+// Returns the digits_bound of every digit count the defaults can draw, so
+// that it is computed once rather than for every group of digits.  Counts
+// only a -format template asks for are computed as they are drawn.
+func digits_bounds(defaults Defaults) map[int]*big.Int {
+
+	var (
+		bounds map[int]*big.Int = make(map[int]*big.Int)
+		groups [6]int
+	)
+
+	groups[0], groups[1], groups[2], groups[3], groups[4], groups[5] = digit_groups(defaults)
+	// Each group is a least and a most, which is 0 for a fixed count
+	for i := 0; i < len(groups); i += 2 {
+		var most int = groups[i + 1]
+		if most < groups[i] {
+			most = groups[i]
+		}
+		for count := groups[i]; count <= most; count++ {
+			bounds[count] = digits_bound(count)
+		}
+	}
+
+	return bounds
+}
+
+// Returns num_digits random digits from 0-9, or from the digit_alphabet
+// when it is set
+func random_digits(defaults Defaults, num_digits int) (string, error) {
+
+	var (
+		alphabet []string = defaults.DigitAlphabet
+		m *big.Int
+		found bool
+		n *big.Int
+		digits string
+		err error
+	)
+
+	// A -format template can ask for any count, and strings.Repeat
+	// panics on a negative one
+	if num_digits < 0 {
+		return "", errors.New(fmt.Sprintf("Error: digits needs a count of at least 0 (%d)", num_digits))
+	}
+	// The only number below 10^0 is 0, which would still print as a digit
+	if num_digits == 0 {
+		return "", nil
+	}
+
+	if len(alphabet) > 0 {
+		var (
+			builder strings.Builder
+			i int64
+		)
+		for j := 0; j < num_digits; j++ {
+			i, err = random_int(defaults.Random, int64(len(alphabet)))
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(alphabet[i])
+		}
+		return builder.String(), nil
+	}
+
+	m, found = defaults.DigitsBounds[num_digits]
+	if !found {
+		m = digits_bound(num_digits)
+	}
+
+	n, err = rand.Int(defaults.Random, m)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error during rand.Int: %v", err))
+	}
+
+	digits = n.String()
+
+	return strings.Repeat("0", num_digits - len(digits)) + digits, nil
+
+}
+
+// Picks the words for one password, all different when NoDuplicateWords
+// is set
+func random_words(defaults Defaults) ([]string, error) {
+
+	var (
+		words []string
+		word string
+		used map[string]bool
+		err error
+	)
+
+	words = make([]string, 0, defaults.NumWords)
+	used = make(map[string]bool, defaults.NumWords)
+	for i := 0; i < defaults.NumWords; i++ {
+		word, err = random_word(defaults)
+		for attempt := 1; err == nil && defaults.NoDuplicateWords && used[strings.ToLower(word)]; attempt++ {
+			if attempt == maxAttempts {
+				err = errors.New(fmt.Sprintf("Error: Could not pick a word which is not already in the password after %d attempts", maxAttempts))
+				break
+			}
+			word, err = random_word(defaults)
+		}
+		if err != nil {
+			return nil, err
+		}
+		used[strings.ToLower(word)] = true
+		words = append(words, word)
+	}
+
+	return words, nil
+}
+
+// Returns the separator for each gap between words: the separator_sequence
+// in order, starting again when it runs out, otherwise the one separator
+func word_separators(defaults Defaults, separator string) []string {
+
+	var separators []string
+
+	if defaults.NumWords < 2 {
+		return nil
+	}
+	separators = make([]string, defaults.NumWords - 1)
+	for i := range separators {
+		if len(defaults.SeparatorSequence) > 0 {
+			separators[i] = defaults.SeparatorSequence[i % len(defaults.SeparatorSequence)]
+		} else {
+			separators[i] = separator
+		}
+	}
+
+	return separators
+}
+
+// Returns the words joined by their separators
+func join_words(parts Password) string {
+
+	var builder strings.Builder
+
+	for i, word := range parts.Words {
+		builder.WriteString(word)
+		if i < len(parts.Words) - 1 {
+			builder.WriteString(parts.WordSeparators[i])
+			if i < len(parts.DigitsBetween) && parts.DigitsBetween[i] != "" {
+				builder.WriteString(parts.DigitsBetween[i])
+				builder.WriteString(parts.WordSeparators[i])
+			}
+		}
+	}
+
+	return builder.String()
+}
+
+// Returns the digits for each gap between words, which are empty for the
+// gaps without any
+func random_digits_between(defaults Defaults, numWords int, min int, max int) ([]string, error) {
+
+	var (
+		between []string
+		count int
+		gap int64 = -1
+		err error
+	)
+
+	if numWords < 2 || (defaults.DigitPlacement != DigitsBetweenAll && defaults.DigitPlacement != DigitsRandomGap) {
+		return nil, nil
+	}
+
+	if defaults.DigitPlacement == DigitsRandomGap {
+		gap, err = random_int(defaults.Random, int64(numWords - 1))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	between = make([]string, numWords - 1)
+	for i := range between {
+		if gap >= 0 && int64(i) != gap {
+			continue
+		}
+		count, err = random_count(defaults.Random, min, max)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			between[i], err = random_digits(defaults, count)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return between, nil
+}
+
+// Draws the random parts of one password
+func random_components(defaults Defaults) (Password, error) {
+
+	var (
+		parts Password
+		digitsBefore int
+		digitsAfter int
+		beforeMin, beforeMax, afterMin, afterMax, betweenMin, betweenMax int
+		err error
+	)
+
+	parts.Separator, err = random_separator(defaults)
+	if err != nil {
+		return Password{}, err
+	}
+	beforeMin, beforeMax, afterMin, afterMax, betweenMin, betweenMax = digit_groups(defaults)
+	digitsBefore, err = random_count(defaults.Random, beforeMin, beforeMax)
+	if err != nil {
+		return Password{}, err
+	}
+	digitsAfter, err = random_count(defaults.Random, afterMin, afterMax)
+	if err != nil {
+		return Password{}, err
+	}
+	if defaults.PaddingType == PaddingFixed {
+		parts.PaddingBefore, err = random_count(defaults.Random, defaults.PaddingCharactersBefore, defaults.PaddingCharsBeforeMax)
+		if err != nil {
+			return Password{}, err
+		}
+		parts.PaddingAfter, err = random_count(defaults.Random, defaults.PaddingCharactersAfter, defaults.PaddingCharsAfterMax)
+		if err != nil {
+			return Password{}, err
+		}
+	}
+
+	if defaults.PaddingType == PaddingFixed || defaults.PaddingType == PaddingAdaptive || defaults.PaddingType == PaddingMultiple {
+		if defaults.PaddingCharacter == PaddingRandom {
+			parts.Padding, err = random_padding(defaults)
+			if err != nil {
+				return Password{}, err
+			}
+		} else if defaults.PaddingCharacter == PaddingSeparator {
+			parts.Padding = parts.Separator
+		} else if defaults.PaddingCharacter == PaddingSpecified {
+			parts.Padding = defaults.SymbolAlphabet[0]
+		}
+	}
+
+	if digitsBefore > 0 {
+		parts.DigitsBefore, err = random_digits(defaults, digitsBefore)
+		if err != nil {
+			return Password{}, err
+		}
+	}
+	parts.Words, err = random_words(defaults)
+	if err != nil {
+		return Password{}, err
+	}
+	parts.WordSeparators = word_separators(defaults, parts.Separator)
+	parts.DigitsBetween, err = random_digits_between(defaults, len(parts.Words), betweenMin, betweenMax)
+	if err != nil {
+		return Password{}, err
+	}
+	if digitsAfter > 0 {
+		parts.DigitsAfter, err = random_digits(defaults, digitsAfter)
+		if err != nil {
+			return Password{}, err
+		}
+	}
+
+	return parts, nil
+}
+
+// Returns the number of bytes assemble_password appends for the parts,
+// before any title casing, injected symbols or adaptive padding, each of
+// which builds a new buffer and wipes the old one
+func assembled_length(defaults Defaults, parts Password) int {
+
+	var length int = (parts.PaddingBefore + parts.PaddingAfter) * len(parts.Padding)
+
+	if defaults.SeparatePaddingDigits && parts.PaddingBefore > 0 && parts.DigitsBefore != "" {
+		length += len(parts.Separator)
+	}
+	if parts.DigitsBefore != "" {
+		length += len(parts.DigitsBefore) + len(parts.Separator)
+	}
+	for i, word := range parts.Words {
+		length += len(word)
+		if i < len(parts.Words) - 1 {
+			length += len(parts.WordSeparators[i])
+			if i < len(parts.DigitsBetween) && parts.DigitsBetween[i] != "" {
+				length += len(parts.DigitsBetween[i]) + len(parts.WordSeparators[i])
+			}
+		}
+	}
+	if parts.DigitsAfter != "" {
+		length += len(parts.Separator) + len(parts.DigitsAfter)
+	}
+	if defaults.SeparatePaddingDigits && parts.PaddingAfter > 0 && parts.DigitsAfter != "" {
+		length += len(parts.Separator)
+	}
+
+	return length
+}
+
+// Puts the parts together in the default order, or through the format,
+// then applies title case, injected symbols and adaptive padding, which
+// may draw further random numbers
+func assemble_password(defaults Defaults, parts Password) ([]byte, error) {
+
+	var (
+		result []byte
+		separator string = parts.Separator
+		padding string = parts.Padding
+		err error
+	)
+
+	if defaults.Format != nil {
+		var buffer bytes.Buffer
+		var data = FormatData{
+			Words:		join_words(parts),
+			WordList:	parts.Words,
+			Separator:	separator,
+			DigitsBefore:	parts.DigitsBefore,
+			DigitsAfter:	parts.DigitsAfter,
+			PaddingBefore:	strings.Repeat(padding, parts.PaddingBefore),
+			PaddingAfter:	strings.Repeat(padding, parts.PaddingAfter),
+		}
+		err = defaults.Format.Execute(&buffer, data)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error: Could not render the format: %v", err))
+		}
+		result = append([]byte{}, buffer.Bytes()...)
+		zero_bytes(buffer.Bytes())
+	} else {
+		// Size the buffer up front so appending never leaves a copy behind
+		result = make([]byte, 0, assembled_length(defaults, parts))
+
+		for i := 0; i < parts.PaddingBefore; i++ {
+			result = append(result, padding...)
+		}
+		if defaults.SeparatePaddingDigits && parts.PaddingBefore > 0 && parts.DigitsBefore != "" {
+			result = append(result, separator...)
+		}
+
+		if parts.DigitsBefore != "" {
+			result = append(result, parts.DigitsBefore...)
+			result = append(result, separator...)
+		}
+
+		for i, word := range parts.Words {
+			result = append(result, word...)
+			if i < len(parts.Words) - 1 {
+				result = append(result, parts.WordSeparators[i]...)
+				if i < len(parts.DigitsBetween) && parts.DigitsBetween[i] != "" {
+					result = append(result, parts.DigitsBetween[i]...)
+					result = append(result, parts.WordSeparators[i]...)
+				}
+			}
+		}
+
+		if parts.DigitsAfter != "" {
+			result = append(result, separator...)
+			result = append(result, parts.DigitsAfter...)
+		}
+
+		if defaults.SeparatePaddingDigits && parts.PaddingAfter > 0 && parts.DigitsAfter != "" {
+			result = append(result, separator...)
+		}
+		for i := 0; i < parts.PaddingAfter; i++ {
+			result = append(result, padding...)
+		}
+	}
+
+	defaults.Log.Debugf("len builder = %v", len(result))
+
+	if defaults.CaseTransform == CaseTitle {
+		result = replace_bytes(result, title_case(result))
+	}
+
+	if defaults.InjectSymbolProbability > 0 {
+		var injected []byte
+		injected, err = inject_symbols(defaults, result)
+		if err != nil {
+			zero_bytes(result)
+			return nil, err
+		}
+		result = replace_bytes(result, injected)
+	}
+
+	if defaults.PaddingType == PaddingAdaptive {
+		var target int
+		target, err = target_length(defaults)
+		if err != nil {
+			zero_bytes(result)
+			return nil, err
+		}
+		defaults.Log.Debugf("target length = %v", target)
+		// Counted in runes so that truncating never splits a multibyte
+		// separator or padding symbol
+		if utf8.RuneCount(result) > target {
+			var start, end int
+			_, start = utf8.DecodeRune(result)
+			end = start
+			for i := 0; i < target; i++ {
+				_, size := utf8.DecodeRune(result[end:])
+				end += size
+			}
+			result = replace_bytes(result, append([]byte{}, result[start:end]...))
+		} else if utf8.RuneCount(result) < target {
+			var length = target - utf8.RuneCount(result)
+			var padded = make([]byte, 0, len(result) + length * len(padding))
+			padded = append(padded, result...)
+			for i := 0; i < length; i++ {
+				padded = append(padded, padding...)
+			}
+			result = replace_bytes(result, padded)
+		}
+	}
+
+	// Never truncates, so the whole password is kept.  Counted in runes so
+	// that a multibyte padding symbol counts once.
+	if defaults.PaddingType == PaddingMultiple && utf8.RuneCount(result) % defaults.PadToMultiple != 0 {
+		var length = defaults.PadToMultiple - utf8.RuneCount(result) % defaults.PadToMultiple
+		var padded = make([]byte, 0, len(result) + length * len(padding))
+		padded = append(padded, result...)
+		for i := 0; i < length; i++ {
+			padded = append(padded, padding...)
+		}
+		result = replace_bytes(result, padded)
+	}
+
+	return result, err
+}
+
+// Overwrites the buffer with zeros.  Call it on the result of
+// generate_password_bytes once the password is no longer needed.
+func zero_bytes(buffer []byte) {
+
+	for i := range buffer {
+		buffer[i] = 0
+	}
+}
+
+// Wipes the old buffer and returns its replacement
+func replace_bytes(old []byte, replacement []byte) []byte {
+
+	zero_bytes(old)
+
+	return replacement
+}
+
+// Inserts a random symbol into each gap between two characters with the
+// configured probability.  There is at most one symbol per gap, so the
+// password at most doubles in length.
+func inject_symbols(defaults Defaults, password []byte) ([]byte, error) {
+
+	var (
+		result []byte = make([]byte, 0, 2 * len(password) * utf8.UTFMax)
+		r rune
+		size int
+		draw float64
+		symbol string
+		err error
+	)
+
+	for i := 0; i < len(password); i += size {
+		r, size = utf8.DecodeRune(password[i:])
+		result = utf8.AppendRune(result, r)
+		if i + size >= len(password) {
+			break
+		}
+		draw, err = random_float(defaults.Random)
+		if err != nil {
+			zero_bytes(result)
+			return nil, err
+		}
+		if draw < defaults.InjectSymbolProbability {
+			symbol, err = random_padding(defaults)
+			if err != nil {
+				zero_bytes(result)
+				return nil, err
+			}
+			result = append(result, symbol...)
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the length adaptive padding should pad or truncate to, which is
+// random for every password when a length histogram or a total length
+// range is configured
+func target_length(defaults Defaults) (int, error) {
+
+	if len(defaults.HistogramLengths) > 0 {
+		i, err := random_weighted_index(defaults.Random, defaults.HistogramWeights)
+		if err != nil {
+			return 0, err
+		}
+		return defaults.HistogramLengths[i], nil
+	}
+	if defaults.MinTotalLength > 0 && defaults.MaxTotalLength > 0 {
+		return random_between(defaults.Random, defaults.MinTotalLength, defaults.MaxTotalLength)
+	}
+
+	return defaults.PadToLength, nil
+}
+
+// Returns the shortest length target_length can return
+func shortest_target_length(defaults Defaults) int {
+
+	var shortest int
+
+	if len(defaults.HistogramLengths) > 0 {
+		shortest = defaults.HistogramLengths[0]
+		for _, length := range defaults.HistogramLengths {
+			if length < shortest {
+				shortest = length
+			}
+		}
+		return shortest
+	}
+	if defaults.MinTotalLength > 0 && defaults.MaxTotalLength > 0 {
+		return defaults.MinTotalLength
+	}
+
+	return defaults.PadToLength
+}
+
+// Returns the most characters there can be from the start of the password
+// to the end of the last word, with the longest words and digit groups,
+// which adaptive truncation has to keep for every word to stay whole
+func maximum_words_length(defaults Defaults) int {
+
+	var (
+		separator int = 1
+		wordLength int = defaults.WordLengthMax
+		length int
+		digitsBefore, digitsBeforeMax, digitsBetween, digitsBetweenMax int
+	)
+
+	if defaults.SeparatorCharacter == SeparatorNone {
+		separator = 0
+	}
+	if defaults.Mode == WordsSyllable {
+		wordLength = 2 * defaults.SyllablesPerWord
+	} else if candidates := candidate_words(defaults); len(candidates) > 0 {
+		// The dictionary may have nothing as long as word_length_max
+		wordLength = 0
+		for _, word := range candidates {
+			if utf8.RuneCountInString(word) > wordLength {
+				wordLength = utf8.RuneCountInString(word)
+			}
+		}
+	}
+	digitsBefore, digitsBeforeMax, _, _, digitsBetween, digitsBetweenMax = digit_groups(defaults)
+	if digitsBeforeMax > digitsBefore {
+		digitsBefore = digitsBeforeMax
+	}
+	if digitsBetweenMax > digitsBetween {
+		digitsBetween = digitsBetweenMax
+	}
+
+	length = defaults.NumWords * wordLength + (defaults.NumWords - 1) * separator
+	if digitsBefore > 0 {
+		length += digitsBefore + separator
+	}
+	if digitsBetween > 0 {
+		switch defaults.DigitPlacement {
+		case DigitsBetweenAll:
+			length += (defaults.NumWords - 1) * (digitsBetween + separator)
+		case DigitsRandomGap:
+			length += digitsBetween + separator
+		}
+	}
+
+	return length
+}
+
+// Uppercases the first letter of every word in the password, where a word
+// is a run of letters, and leaves everything else as it is
+func title_case(password []byte) []byte {
+
+	var (
+		result []byte = make([]byte, 0, len(password) * utf8.UTFMax)
+		previous rune = ' '
+		r rune
+		size int
+	)
+
+	for i := 0; i < len(password); i += size {
+		r, size = utf8.DecodeRune(password[i:])
+		if unicode.IsLetter(r) && !unicode.IsLetter(previous) {
+			result = utf8.AppendRune(result, unicode.ToUpper(r))
+		} else {
+			result = utf8.AppendRune(result, r)
+		}
+		previous = r
+	}
+
+	return result
+}
+
+// Returns the length of the longest run of identical adjacent characters
+func longest_identical_run(value []byte) int {
+
+	var (
+		longest int = 0
+		current int = 0
+		previous rune
+		r rune
+		size int
+	)
+
+	for i := 0; i < len(value); i += size {
+		r, size = utf8.DecodeRune(value[i:])
+		if i > 0 && r == previous {
+			current++
+		} else {
+			current = 1
+		}
+		if current > longest {
+			longest = current
+		}
+		previous = r
+	}
+
+	return longest
+}
+
+// Generates a password and the parts it was built from, regenerating it
+// when it breaks one of the rules.  The password is returned as bytes,
+// leaving String empty, and rejected attempts are wiped along the way.
+func generate_password_buffer(defaults Defaults) (Password, []byte, error) {
+
+	var (
+		parts Password
+		result []byte
+		err error
+	)
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		parts, err = random_components(defaults)
+		if err != nil {
+			return Password{}, nil, err
+		}
+		result, err = assemble_password(defaults, parts)
+		if err != nil {
+			return Password{}, nil, err
+		}
+
+		if defaults.MaxIdenticalAdjacent > 0 && longest_identical_run(result) > defaults.MaxIdenticalAdjacent {
+			defaults.Log.Debugf("Rejecting password with more than %v identical adjacent characters", defaults.MaxIdenticalAdjacent)
+			zero_bytes(result)
+			continue
+		}
+
+		return parts, result, nil
+	}
+
+	return Password{}, nil, errors.New(fmt.Sprintf("Error: Could not generate a password within the rules after %d attempts", maxAttempts))
+}
+
+// Generates a password, regenerating it when it breaks one of the rules.
+// The result can be wiped with zero_bytes, unlike the string returned by
+// generate_password.
+func generate_password_bytes(defaults Defaults) ([]byte, error) {
+
+	var (
+		result []byte
+		err error
+	)
+
+	_, result, err = generate_password_buffer(defaults)
+
+	return result, err
+}
+
+// Generates count passwords using that many workers.  Each password is
+// stored at its own index, so the order does not depend on which worker
+// finishes first.  The random reader is safe for concurrent use and the
+// defaults are only read.
+func generate_passwords_parallel(defaults Defaults, count int, workers int) ([]Password, error) {
+
+	var (
+		passwords []Password = make([]Password, count)
+		errs []error = make([]error, count)
+		indexes chan int = make(chan int)
+		wg sync.WaitGroup
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				passwords[i], errs[i] = generate_password_parts(defaults)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return passwords, nil
+}
+
+// Generates a password along with the parts it was built from,
+// regenerating it when it breaks one of the rules
+func generate_password_parts(defaults Defaults) (Password, error) {
+
+	var (
+		parts Password
+		result []byte
+		err error
+	)
+
+	parts, result, err = generate_password_buffer(defaults)
+	if err != nil {
+		return Password{}, err
+	}
+	parts.String = string(result)
+	zero_bytes(result)
+
+	return parts, nil
+}
+
+// Generates a password, regenerating it when it breaks one of the rules
+func generate_password(defaults Defaults) (string, error) {
+
+	var (
+		buffer []byte
+		result string
+		err error
+	)
+
+	buffer, err = generate_password_bytes(defaults)
+	if err != nil {
+		return "", err
+	}
+	result = string(buffer)
+	zero_bytes(buffer)
+
+	return result, nil
+}
+
+// Writes the password with the 0-based index i, followed by terminator
+func write_password(out io.Writer, i int, password string, indexPrefix bool, terminator string) error {
+
+	var err error
+
+	if indexPrefix {
+		_, err = fmt.Fprintf(out, "%d: ", i + 1)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(out, "%v%v", password, terminator)
+
+	return err
+}
+
+// Quotes a string for a POSIX shell, so that spaces and symbols in it
+// survive word splitting and expansion
+func shell_quote(str string) string {
+
+	return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
+}
+
+// Lays out the passwords for the output format: plain leaves them alone,
+// shell quotes each one and env turns each one into a quoted assignment to
+// XKCD_PASSWORD, numbered from 1 when there is more than one
+func format_passwords(passwords []string, format string) ([]string, error) {
+
+	var (
+		formatted []string = make([]string, len(passwords))
+	)
+
+	for i, password := range passwords {
+		switch format {
+		case "plain":
+			formatted[i] = password
+		case "shell":
+			formatted[i] = shell_quote(password)
+		case "env":
+			if len(passwords) == 1 {
+				formatted[i] = "XKCD_PASSWORD=" + shell_quote(password)
+			} else {
+				formatted[i] = fmt.Sprintf("XKCD_PASSWORD_%d=%v", i + 1, shell_quote(password))
+			}
+		default:
+			return nil, errors.New(fmt.Sprintf("Error: Unknown output format %v (plain, shell, env)", format))
+		}
+	}
+
+	return formatted, nil
+}
+
+// Writes separator between the passwords and terminator after the last
+func write_passwords(out io.Writer, passwords []string, indexPrefix bool, separator string, terminator string) error {
+
+	var err error
+
+	for i, password := range passwords {
+		if i < len(passwords) - 1 {
+			err = write_password(out, i, password, indexPrefix, separator)
+		} else {
+			err = write_password(out, i, password, indexPrefix, terminator)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Opens the file to append passwords to, creating it if needed.  When the
+// file does not already end with the terminator, as after
+// -no-trailing-newline, the separator is written first so the new
+// passwords do not run into the old ones.
+func open_append(path string, separator string, terminator string) (*os.File, error) {
+
+	var (
+		file *os.File
+		info os.FileInfo
+		last []byte
+		err error
+	)
+
+	// The same mode os.Create gives a file replaced without -append
+	file, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err = file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return file, nil
+	}
+
+	if terminator != "" && info.Size() >= int64(len(terminator)) {
+		last = make([]byte, len(terminator))
+		_, err = file.ReadAt(last, info.Size() - int64(len(terminator)))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if string(last) == terminator {
+			return file, nil
+		}
+	}
+
+	_, err = io.WriteString(file, separator)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// An entropy of zero leaves it out of the output
+func write_json(out io.Writer, passwords []string, entropy float64) error {
+
+	var (
+		json_passwords []JSON_Password
+		jsonData []byte
+		err error
+	)
+
+	// Always emit an array, even for a single password
+	json_passwords = make([]JSON_Password, 0, len(passwords))
+	for _, password := range passwords {
+		json_passwords = append(json_passwords, JSON_Password{ Password: password, Entropy: entropy })
+	}
+
+	jsonData, err = json.MarshalIndent(json_passwords, "", " ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "%s\n", jsonData)
+
+	return err
+}
+
+// Returns the number of different dictionary words within the length
+// bounds, and their average length as drawn
+func count_candidate_words(defaults Defaults) (int, float64) {
+
+	var (
+		count int = 0
+		total_length int = 0
+		distinct map[string]bool = map[string]bool{}
+	)
+
+	// Every syllable is a consonant and a vowel
+	if defaults.Mode == WordsSyllable {
+		return int(math.Pow(float64(len(syllableConsonants) * len(syllableVowels)), float64(defaults.SyllablesPerWord))), float64(2 * defaults.SyllablesPerWord)
+	}
+
+	for _, word := range defaults.WordDictionary {
+		if defaults.OnlyLetters {
+			word = only_letters(word)
+		}
+		if len(word) >= defaults.WordLengthMin && len(word) <= defaults.WordLengthMax {
+			count++
+			total_length += len(word)
+			distinct[word] = true
+		}
+	}
+
+	if count == 0 {
+		return 0, 0
+	}
+
+	return len(distinct), float64(total_length) / float64(count)
+}
+
+// Returns the entropy in bits of drawing one of the words, where a word
+// listed more than once, as -merge-strategy concat allows, is that much
+// more likely rather than another choice
+func draw_entropy(words []string) float64 {
+
+	var (
+		counts map[string]int = make(map[string]int, len(words))
+		entropy float64 = 0
+	)
+
+	for _, word := range words {
+		counts[word]++
+	}
+	for _, count := range counts {
+		var p float64 = float64(count) / float64(len(words))
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// Returns a rough estimate of the entropy left after adaptive padding
+// truncates a password to its shortest target length, assuming the
+// entropy is spread evenly over the characters
+func truncated_entropy(defaults Defaults, entropy float64) float64 {
+
+	var (
+		average_length float64
+		length float64
+		separator float64 = 1
+	)
+
+	if defaults.PaddingType != PaddingAdaptive || defaults.Format != nil {
+		return entropy
+	}
+	if defaults.SeparatorCharacter == SeparatorNone {
+		separator = 0
+	}
+
+	_, average_length = count_candidate_words(defaults)
+	length = float64(defaults.NumWords) * average_length + float64(defaults.NumWords - 1) * separator
+	if defaults.PaddingDigitsBefore > 0 {
+		length += float64(defaults.PaddingDigitsBefore) + separator
+	}
+	if defaults.PaddingDigitsAfter > 0 {
+		length += float64(defaults.PaddingDigitsAfter) + separator
+	}
+	if length <= 0 || float64(shortest_target_length(defaults)) >= length {
+		return entropy
+	}
+
+	return entropy * float64(shortest_target_length(defaults)) / length
+}
+
+// Returns the entropy of a group of digits, each one of base choices,
+// whose count is uniform in [min, max]: the choice of count plus the
+// average number of digits
+func digits_entropy(min int, max int, base int) float64 {
+
+	if max <= min {
+		return float64(min) * math.Log2(float64(base))
+	}
+
+	return math.Log2(float64(max - min + 1)) + float64(min + max) / 2 * math.Log2(float64(base))
+}
+
+// The entropy in bits contributed by each part of a password
+type EntropyBreakdown struct {
+	Words			float64		`json:"words"`
+	DigitsBefore		float64		`json:"digits_before"`
+	DigitsAfter		float64		`json:"digits_after"`
+	DigitsBetween		float64		`json:"digits_between"`
+	Separators		float64		`json:"separators"`
+	Padding			float64		`json:"padding"`
+	Case			float64		`json:"case"`
+	Leet			float64		`json:"leet"`
+	Total			float64		`json:"total"`
+}
+
+// Returns the entropy in bits of a password generated from the defaults,
+// assuming the attacker knows the configuration and the dictionary.
+func calculate_entropy(defaults Defaults) float64 {
+
+	return entropy_breakdown(defaults).Total
+}
+
+// Returns the entropy of each part of a password generated from the
+// defaults, which calculate_entropy totals
+func entropy_breakdown(defaults Defaults) EntropyBreakdown {
+
+	var (
+		breakdown EntropyBreakdown
+		count int
+		average_length float64
+		digitBase int = 10
+		beforeMin, beforeMax, afterMin, afterMax, betweenMin, betweenMax int
+	)
+
+	count, average_length = count_candidate_words(defaults)
+	if count > 0 && defaults.WordSelection == SelectByLength && defaults.Mode == WordsDictionary {
+		// The choice of length plus the choice of word within it, ignoring
+		// no_duplicate_words
+		var buckets, weights = word_buckets(defaults, candidate_words(defaults))
+		var total, perWord float64
+		for _, weight := range weights {
+			total += weight
+		}
+		for i, weight := range weights {
+			if weight > 0 {
+				var p float64 = weight / total
+				perWord += p * (draw_entropy(buckets[i]) - math.Log2(p))
+			}
+		}
+		breakdown.Words = float64(defaults.NumWords) * perWord
+	} else if count > 0 {
+		var perWord float64 = math.Log2(float64(count))
+		if defaults.Mode == WordsDictionary {
+			perWord = draw_entropy(candidate_words(defaults))
+		}
+		if defaults.NoDuplicateWords {
+			// Each word has one fewer choice than the one before
+			for i := 0; i < defaults.NumWords && i < count; i++ {
+				breakdown.Words += math.Min(perWord, math.Log2(float64(count - i)))
+			}
+		} else {
+			breakdown.Words = float64(defaults.NumWords) * perWord
+		}
+	}
+
+	switch defaults.CaseTransform {
+	case CaseRandom:
+		// Each character is a coin weighted by uppercase_ratio
+		if defaults.UppercaseRatio > 0 && defaults.UppercaseRatio < 1 {
+			var p float64 = defaults.UppercaseRatio
+			breakdown.Case = float64(defaults.NumWords) * average_length * -(p * math.Log2(p) + (1 - p) * math.Log2(1 - p))
+		}
+	case CaseWordRandom:
+		breakdown.Case = float64(defaults.NumWords)
+	}
+
+	if defaults.SeparatorCharacter == SeparatorRandom && len(defaults.SeparatorAlphabet) > 0 {
+		if len(defaults.SeparatorWeights) > 0 {
+			var total float64 = 0
+			for _, weight := range defaults.SeparatorWeights {
+				total += weight
+			}
+			for _, weight := range defaults.SeparatorWeights {
+				if weight > 0 {
+					breakdown.Separators -= weight / total * math.Log2(weight / total)
+				}
+			}
+		} else {
+			breakdown.Separators = math.Log2(float64(len(defaults.SeparatorAlphabet)))
+		}
+	}
+
+	if len(defaults.DigitAlphabet) > 0 {
+		digitBase = len(defaults.DigitAlphabet)
+	}
+	beforeMin, beforeMax, afterMin, afterMax, betweenMin, betweenMax = digit_groups(defaults)
+	breakdown.DigitsBefore = digits_entropy(beforeMin, beforeMax, digitBase)
+	breakdown.DigitsAfter = digits_entropy(afterMin, afterMax, digitBase)
+	switch defaults.DigitPlacement {
+	case DigitsBetweenAll:
+		breakdown.DigitsBetween = float64(defaults.NumWords - 1) * digits_entropy(betweenMin, betweenMax, digitBase)
+	case DigitsRandomGap:
+		// Plus the choice of gap
+		breakdown.DigitsBetween = digits_entropy(betweenMin, betweenMax, digitBase) + math.Log2(float64(defaults.NumWords - 1))
+	}
+
+	// The choice of how many padding symbols there are
+	if defaults.PaddingType == PaddingFixed {
+		if defaults.PaddingCharsBeforeMax > defaults.PaddingCharactersBefore {
+			breakdown.Padding += math.Log2(float64(defaults.PaddingCharsBeforeMax - defaults.PaddingCharactersBefore + 1))
+		}
+		if defaults.PaddingCharsAfterMax > defaults.PaddingCharactersAfter {
+			breakdown.Padding += math.Log2(float64(defaults.PaddingCharsAfterMax - defaults.PaddingCharactersAfter + 1))
+		}
+	}
+
+	if defaults.PaddingType != PaddingNone && defaults.PaddingCharacter == PaddingRandom && len(defaults.SymbolAlphabet) > 0 {
+		breakdown.Padding += math.Log2(float64(len(defaults.SymbolAlphabet)))
+	}
+
+	// Every letter with a leet substitution adds the entropy of a coin
+	// weighted by leet_probability
+	if defaults.LeetProbability > 0 && defaults.LeetProbability < 1 && count > 0 {
+		var (
+			p float64 = defaults.LeetProbability
+			eligible int = 0
+			substitutions map[rune]string = leet_map(defaults)
+		)
+		for _, word := range candidate_words(defaults) {
+			for _, r := range word {
+				if _, found := substitutions[unicode.ToLower(r)]; found {
+					eligible++
+				}
+			}
+		}
+		breakdown.Leet = float64(defaults.NumWords) * float64(eligible) / float64(count) * -(p * math.Log2(p) + (1 - p) * math.Log2(1 - p))
+	}
+
+	// Injected symbols are not counted, so this is a lower bound when
+	// inject_symbol_probability is set
+	breakdown.Total = breakdown.Words + breakdown.DigitsBefore + breakdown.DigitsAfter + breakdown.DigitsBetween + breakdown.Separators + breakdown.Padding + breakdown.Case + breakdown.Leet
+
+	return breakdown
+}
+
+// Returns a readable estimate, like "~4 centuries", of how long guessing a
+// password with this entropy takes on average at guessRate guesses a
+// second.  big.Float keeps astronomically long times from overflowing.
+func guess_time(bits float64, guessRate float64) string {
+
+	var (
+		whole float64
+		fraction float64
+		seconds *big.Float
+		years *big.Float
+		mantissa *big.Float = new(big.Float)
+		exponent int
+		value float64
+		units = []struct {
+			name string
+			plural string
+			seconds float64
+			limit float64
+		}{
+			{ "second", "seconds", 1, 60 },
+			{ "minute", "minutes", 60, 60 },
+			{ "hour", "hours", 3600, 24 },
+			{ "day", "days", 86400, 365.25 },
+			{ "year", "years", 31557600, 100 },
+			{ "century", "centuries", 3155760000, 10 },
+			{ "millennium", "millennia", 31557600000, 1000 },
+		}
+	)
+
+	if bits < 1 {
+		return "instantly"
+	}
+
+	// On average half of the 2^bits passwords are tried
+	whole, fraction = math.Modf(bits - 1)
+	seconds = new(big.Float).SetMantExp(big.NewFloat(math.Pow(2, fraction)), int(whole))
+	seconds.Quo(seconds, big.NewFloat(guessRate))
+
+	for _, unit := range units {
+		value, _ = new(big.Float).Quo(seconds, big.NewFloat(unit.seconds)).Float64()
+		if value < 1 && unit.seconds == 1 {
+			return "less than a second"
+		}
+		if value < unit.limit && math.Round(value) == 1 {
+			return fmt.Sprintf("~1 %v", unit.name)
+		} else if value < unit.limit {
+			return fmt.Sprintf("~%.0f %v", value, unit.plural)
+		}
+	}
+
+	// log10 of the years from their binary mantissa and exponent
+	years = new(big.Float).Quo(seconds, big.NewFloat(31557600))
+	exponent = years.MantExp(mantissa)
+	value, _ = mantissa.Float64()
+
+	return fmt.Sprintf("~10^%.0f years", math.Floor((math.Log2(value) + float64(exponent)) * math.Log10(2)))
+}
+
+// Returns the entropy of the choice of how many digits a group has, when
+// the count is uniform in [min, max]
+func digit_count_entropy(min int, max int) float64 {
+
+	if max <= min {
+		return 0
+	}
+
+	return math.Log2(float64(max - min + 1))
+}
+
+// Returns the entropy in bits of one password generated from the defaults.
+// It is entropy_breakdown with the digits and the random case counted for
+// the digits and letters this password has rather than on average, so it
+// only differs between passwords where their parts do, and averages out
+// to calculate_entropy.
+func password_entropy(defaults Defaults, parts Password) float64 {
+
+	var (
+		breakdown EntropyBreakdown = entropy_breakdown(defaults)
+		perDigit float64 = math.Log2(10)
+		beforeMin, beforeMax, afterMin, afterMax, betweenMin, betweenMax int
+	)
+
+	if len(defaults.DigitAlphabet) > 0 {
+		perDigit = math.Log2(float64(len(defaults.DigitAlphabet)))
+	}
+	beforeMin, beforeMax, afterMin, afterMax, betweenMin, betweenMax = digit_groups(defaults)
+	breakdown.DigitsBefore = digit_count_entropy(beforeMin, beforeMax) + float64(utf8.RuneCountInString(parts.DigitsBefore)) * perDigit
+	breakdown.DigitsAfter = digit_count_entropy(afterMin, afterMax) + float64(utf8.RuneCountInString(parts.DigitsAfter)) * perDigit
+	switch defaults.DigitPlacement {
+	case DigitsBetweenAll:
+		breakdown.DigitsBetween = 0
+		for _, digits := range parts.DigitsBetween {
+			breakdown.DigitsBetween += digit_count_entropy(betweenMin, betweenMax) + float64(utf8.RuneCountInString(digits)) * perDigit
+		}
+	case DigitsRandomGap:
+		breakdown.DigitsBetween = digit_count_entropy(betweenMin, betweenMax) + math.Log2(float64(defaults.NumWords - 1))
+		for _, digits := range parts.DigitsBetween {
+			breakdown.DigitsBetween += float64(utf8.RuneCountInString(digits)) * perDigit
+		}
+	}
+
+	if defaults.CaseTransform == CaseRandom && defaults.UppercaseRatio > 0 && defaults.UppercaseRatio < 1 {
+		var (
+			p float64 = defaults.UppercaseRatio
+			letters int = 0
+		)
+		for _, word := range parts.Words {
+			letters += utf8.RuneCountInString(word)
+		}
+		breakdown.Case = float64(letters) * -(p * math.Log2(p) + (1 - p) * math.Log2(1 - p))
+	}
+
+	return breakdown.Words + breakdown.DigitsBefore + breakdown.DigitsAfter + breakdown.DigitsBetween + breakdown.Separators + breakdown.Padding + breakdown.Case + breakdown.Leet
+}
+
+// Returns the passwords whose password_entropy is at least floor,
+// strongest first
+func filter_by_entropy_floor(defaults Defaults, passwords []Password, floor float64) []Password {
+
+	var (
+		result []Password
+		entropies map[string]float64
+	)
+
+	entropies = make(map[string]float64, len(passwords))
+	result = make([]Password, 0, len(passwords))
+	for _, password := range passwords {
+		entropies[password.String] = password_entropy(defaults, password)
+		if entropies[password.String] >= floor {
+			result = append(result, password)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return entropies[result[i].String] > entropies[result[j].String]
+	})
+
+	return result
+}
+
+// Returns the entries of the alphabet which are in allowed, along with their
+// weights if there are any
+func filter_alphabet(alphabet []string, weights []float64, allowed string) ([]string, []float64) {
+
+	var (
+		filtered []string
+		filtered_weights []float64
+	)
+
+	for i, entry := range alphabet {
+		if !strings.Contains(allowed, entry) {
+			continue
+		}
+		filtered = append(filtered, entry)
+		if i < len(weights) {
+			filtered_weights = append(filtered_weights, weights[i])
+		}
+	}
+
+	return filtered, filtered_weights
+}
+
+// Restricts the separators and padding symbols to those which are easy to
+// type on the keyboard layout
+func apply_keyboard_layout(defaults Defaults, layout string) (Defaults, error) {
+
+	var (
+		allowed string
+		found bool
+	)
+
+	allowed, found = keyboardLayouts[strings.ToLower(layout)]
+	if !found {
+		return Defaults{}, errors.New(fmt.Sprintf("Error: Unknown keyboard layout: %v", layout))
+	}
+
+	if defaults.SeparatorCharacter == SeparatorCharacter && !strings.Contains(allowed, defaults.SeparatorAlphabet[0]) {
+		return Defaults{}, errors.New(fmt.Sprintf("Error: The separator %v cannot be typed on the %v keyboard layout", defaults.SeparatorAlphabet[0], layout))
+	}
+	if defaults.PaddingCharacter == PaddingSpecified && !strings.Contains(allowed, defaults.SymbolAlphabet[0]) {
+		return Defaults{}, errors.New(fmt.Sprintf("Error: The padding character %v cannot be typed on the %v keyboard layout", defaults.SymbolAlphabet[0], layout))
+	}
+
+	if defaults.SeparatorCharacter == SeparatorRandom {
+		defaults.SeparatorAlphabet, defaults.SeparatorWeights = filter_alphabet(defaults.SeparatorAlphabet, defaults.SeparatorWeights, allowed)
+	}
+	if defaults.PaddingCharacter == PaddingRandom {
+		defaults.SymbolAlphabet, _ = filter_alphabet(defaults.SymbolAlphabet, nil, allowed)
+	}
+	if len(defaults.SeparatorSequence) > 0 {
+		defaults.SeparatorSequence, _ = filter_alphabet(defaults.SeparatorSequence, nil, allowed)
+		if len(defaults.SeparatorSequence) == 0 {
+			return Defaults{}, errors.New(fmt.Sprintf("Error: No separator_sequence entry can be typed on the %v keyboard layout", layout))
+		}
+	}
+
+	return defaults, nil
+}
+
+// Characters easily mistaken for each other when read aloud or typed,
+// unless ambiguous_characters says otherwise
+const defaultAmbiguousCharacters string = "Il1|O0"
+
+func ambiguous_characters(defaults Defaults) string {
+
+	if defaults.AmbiguousCharacters != "" {
+		return defaults.AmbiguousCharacters
+	}
+
+	return defaultAmbiguousCharacters
+}
+
+// Returns the entries of the alphabet, and their weights, which contain
+// none of the ambiguous characters
+func remove_ambiguous(alphabet []string, weights []float64, ambiguous string) ([]string, []float64) {
+
+	var (
+		filtered []string
+		filtered_weights []float64
+	)
+
+	for i, entry := range alphabet {
+		if strings.ContainsAny(entry, ambiguous) {
+			continue
+		}
+		filtered = append(filtered, entry)
+		if i < len(weights) {
+			filtered_weights = append(filtered_weights, weights[i])
+		}
+	}
+
+	return filtered, filtered_weights
+}
+
+// Removes the ambiguous characters from the separators, padding symbols,
+// digits and leet substitutions
+func apply_avoid_ambiguous(defaults Defaults) (Defaults, error) {
+
+	var (
+		ambiguous string = ambiguous_characters(defaults)
+		digits []string = defaults.DigitAlphabet
+		substitutions map[rune]string
+	)
+
+	if defaults.SeparatorCharacter == SeparatorCharacter && strings.ContainsAny(defaults.SeparatorAlphabet[0], ambiguous) {
+		return Defaults{}, errors.New(fmt.Sprintf("Error: The separator %v is ambiguous", defaults.SeparatorAlphabet[0]))
+	}
+	if defaults.PaddingCharacter == PaddingSpecified && strings.ContainsAny(defaults.SymbolAlphabet[0], ambiguous) {
+		return Defaults{}, errors.New(fmt.Sprintf("Error: The padding character %v is ambiguous", defaults.SymbolAlphabet[0]))
+	}
+
+	if defaults.SeparatorCharacter == SeparatorRandom {
+		defaults.SeparatorAlphabet, defaults.SeparatorWeights = remove_ambiguous(defaults.SeparatorAlphabet, defaults.SeparatorWeights, ambiguous)
+	}
+	if defaults.PaddingCharacter == PaddingRandom {
+		defaults.SymbolAlphabet, _ = remove_ambiguous(defaults.SymbolAlphabet, nil, ambiguous)
+	}
+	if len(defaults.SeparatorSequence) > 0 {
+		defaults.SeparatorSequence, _ = remove_ambiguous(defaults.SeparatorSequence, nil, ambiguous)
+		if len(defaults.SeparatorSequence) == 0 {
+			return Defaults{}, errors.New("Error: Every separator_sequence entry is ambiguous")
+		}
+	}
+
+	if len(digits) == 0 {
+		digits = strings.Split("0123456789", "")
+	}
+	defaults.DigitAlphabet, _ = remove_ambiguous(digits, nil, ambiguous)
+	if len(defaults.DigitAlphabet) == 0 {
+		return Defaults{}, errors.New("Error: Every digit is ambiguous")
+	}
+
+	substitutions = make(map[rune]string)
+	for from, to := range leet_map(defaults) {
+		if !strings.ContainsAny(to, ambiguous) {
+			substitutions[from] = to
+		}
+	}
+	defaults.LeetMap = substitutions
+	if len(substitutions) == 0 {
+		defaults.LeetProbability = 0
+	}
+
+	return defaults, nil
+}
+
+// Removes the words with a letter which is ambiguous in either case, since
+// changing the case can turn i into I
+func remove_ambiguous_words(dictionary []string, ambiguous string) []string {
+
+	var result []string = make([]string, 0, len(dictionary))
+
+	for _, word := range dictionary {
+		if strings.ContainsAny(strings.ToLower(word), ambiguous) || strings.ContainsAny(strings.ToUpper(word), ambiguous) {
+			continue
+		}
+		result = append(result, word)
+	}
+
+	return result
+}
+
+// Parses an alphabet given on the command line, either comma separated
+// (-,.,_) or concatenated (-._).  Every entry must be a single character.
+func parse_alphabet(value string) ([]string, error) {
+
+	var alphabet []string
+
+	if utf8.RuneCountInString(value) > 1 && strings.Contains(value, ",") {
+		alphabet = strings.Split(value, ",")
+	} else {
+		for _, r := range value {
+			alphabet = append(alphabet, string(r))
+		}
+	}
+
+	if len(alphabet) == 0 {
+		return nil, errors.New("Error: The alphabet is empty")
+	}
+	for _, entry := range alphabet {
+		if utf8.RuneCountInString(entry) != 1 {
+			return nil, errors.New(fmt.Sprintf("Error: Alphabet entries must be a single character (%q)", entry))
+		}
+	}
+
+	return alphabet, nil
+}
+
+// Parses a range of lengths written as min-max
+func parse_length_range(value string) (int, int, error) {
+
+	var (
+		fields []string
+		min int
+		max int
+		err error
+	)
+
+	fields = strings.Split(value, "-")
+	if len(fields) != 2 {
+		return 0, 0, errors.New(fmt.Sprintf("Error: Length range is not min-max: %v", value))
+	}
+	min, err = strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return 0, 0, errors.New(fmt.Sprintf("Error: Length range is not min-max: %v", value))
+	}
+	max, err = strconv.Atoi(strings.TrimSpace(fields[1]))
+	if err != nil {
+		return 0, 0, errors.New(fmt.Sprintf("Error: Length range is not min-max: %v", value))
+	}
+	if min < 1 || min > max {
+		return 0, 0, errors.New(fmt.Sprintf("Error: Length range must have 1 <= min <= max: %v", value))
+	}
+
+	return min, max, nil
+}
+
+// Parses a -format template.  Besides the fields of FormatData it can call
+// digits with a count for a fresh group of random digits, as in
+// {{range $i, $w := .WordList}}{{if $i}}{{digits 2}}{{end}}{{$w}}{{end}}
+// The digits come from the digit_alphabet when it is set.  It is parsed
+// once the overrides are applied, since it is tried out with num_words
+// words.
+func parse_format(value string, defaults Defaults) (*template.Template, error) {
+
+	var (
+		format *template.Template
+		trial *template.Template
+		words []string = make([]string, defaults.NumWords)
+		err error
+	)
+
+	format, err = template.New("format").Funcs(template.FuncMap{
+		"digits": func(num_digits int) (string, error) {
+			return random_digits(defaults, num_digits)
+		},
+	}).Parse(value)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error: Invalid format: %v", err))
+	}
+
+	// Unknown fields and out of range indexes only show up when the
+	// template runs, so try it out without drawing any random digits
+	trial, err = format.Clone()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error: Invalid format: %v", err))
+	}
+	trial.Funcs(template.FuncMap{
+		"digits": func(num_digits int) (string, error) {
+			if num_digits < 0 {
+				return "", errors.New(fmt.Sprintf("Error: digits needs a count of at least 0 (%d)", num_digits))
+			}
+			return strings.Repeat("0", num_digits), nil
+		},
+	})
+	for i := range words {
+		words[i] = "word"
+	}
+	err = trial.Execute(ioutil.Discard, FormatData{
+		Words: strings.Join(words, "-"),
+		WordList: words,
+		Separator: "-",
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error: Invalid format: %v", err))
+	}
+
+	return format, nil
+}
+
+// Converts a length_histogram, mapping total lengths to weights, into
+// parallel slices sorted by length
+func parse_length_histogram(histogram map[string]float64) ([]int, []float64, error) {
+
+	var (
+		byLength map[int]float64
+		lengths []int
+		weights []float64
+		length int
+		err error
+	)
+
+	if len(histogram) == 0 {
+		return nil, nil, nil
+	}
+
+	byLength = make(map[int]float64, len(histogram))
+	for key, weight := range histogram {
+		length, err = strconv.Atoi(strings.TrimSpace(key))
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Error: length_histogram length is not a number: %v", key))
+		}
+		byLength[length] = weight
+		lengths = append(lengths, length)
+	}
+	sort.Ints(lengths)
+
+	weights = make([]float64, 0, len(lengths))
+	for _, length = range lengths {
+		weights = append(weights, byLength[length])
+	}
+
+	return lengths, weights, nil
+}
+
+// Parses a histogram given as comma separated length:weight pairs
+func parse_length_histogram_flag(value string) ([]int, []float64, error) {
+
+	var (
+		histogram map[string]float64 = map[string]float64{}
+		fields []string
+		weight float64
+		err error
+	)
+
+	for _, pair := range strings.Split(value, ",") {
+		fields = strings.Split(pair, ":")
+		if len(fields) != 2 {
+			return nil, nil, errors.New(fmt.Sprintf("Error: Length histogram is not length:weight,...: %v", value))
+		}
+		weight, err = strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Error: Length histogram is not length:weight,...: %v", value))
+		}
+		if _, found := histogram[strings.TrimSpace(fields[0])]; found {
+			return nil, nil, errors.New(fmt.Sprintf("Error: Length histogram repeats the length %v", fields[0]))
+		}
+		histogram[strings.TrimSpace(fields[0])] = weight
+	}
+
+	return parse_length_histogram(histogram)
+}
+
+// Parses a 1-based selection out of count candidates
+func parse_choice(line string, count int) (int, error) {
+
+	var (
+		choice int
+		err error
+	)
+
+	choice, err = strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error: Selection is not a number: %v", strings.TrimSpace(line)))
+	}
+	if choice < 1 || choice > count {
+		return 0, errors.New(fmt.Sprintf("Error: Selection must be between 1 and %d (%d)", count, choice))
+	}
+
+	return choice, nil
+}
+
+// Lists the candidates on prompt and reads the selection from in
+func choose_password(in io.Reader, prompt io.Writer, candidates []string) (string, error) {
+
+	var (
+		reader *bufio.Reader
+		line string
+		choice int
+		err error
+	)
+
+	for i, candidate := range candidates {
+		fmt.Fprintf(prompt, "%d: %v\n", i + 1, candidate)
+	}
+	fmt.Fprintf(prompt, "Choose a password (1-%d): ", len(candidates))
+
+	reader = bufio.NewReader(in)
+	line, err = reader.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+
+	choice, err = parse_choice(line, len(candidates))
+	if err != nil {
+		return "", err
+	}
+
+	return candidates[choice - 1], nil
+}
+
+// Writes a password to out, then another every time a line is read from
+// in, until in ends or the line is q
+func interactive_loop(in io.Reader, out io.Writer, prompt io.Writer, defaults Defaults) error {
+
+	var (
+		reader *bufio.Reader
+		line string
+		password []byte
+		err error
+	)
+
+	reader = bufio.NewReader(in)
+	for {
+		// Written straight from the buffer so that it can be wiped
+		password, err = generate_password_bytes(defaults)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(password)
+		zero_bytes(password)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(prompt, "Press Enter for another password, or q to quit: ")
+		line, err = reader.ReadString('\n')
+		if err == io.EOF {
+			fmt.Fprintln(prompt)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "q" {
+			return nil
+		}
+	}
+}
+
+// Where the platform keeps configuration, such as %AppData% on Windows.
+// Replaceable so that the search can be pointed at another directory.
+var userConfigDir func() (string, error) = os.UserConfigDir
+
+// Returns the location of the defaults file, looking first for
+// .xkcd-defaults.json in the home directory, then for
+// xkcd-passwd/defaults.json in the platform's configuration directory and
+// then for .xkcd-defaults.json in the current directory.  Finding none is
+// an error listing where it looked.
+func find_defaults_file(log *logrus.Logger) (string, error) {
+
+	var (
+		homeDir string
+		configDir string
+		filenames []string
+		err error
+	)
+
+	// Find home
+	homeDir, err = os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("homeDir = %v", homeDir)
+	filenames = append(filenames, filepath.Join(homeDir, ".xkcd-defaults.json"))
+
+	// Not every environment has one, so it is skipped when missing
+	configDir, err = userConfigDir()
+	if err == nil {
+		filenames = append(filenames, filepath.Join(configDir, "xkcd-passwd", "defaults.json"))
+	} else {
+		log.Debugf("userConfigDir() = %v", err)
+	}
+
+	filenames = append(filenames, ".xkcd-defaults.json")
+
+	for _, filename := range filenames {
+		_, err = os.Stat(filename)
+		log.Debugf("os.Stat(\"%v\") = %v\n", filename, err)
+		if err == nil {
+			return filename, nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("Error: No defaults file found in %v; use -no-config or -preset for the built in defaults", strings.Join(filenames, ", ")))
+}
+
+// Returns the defaults file to read: the -config flag wins, then the
+// XKCD_DEFAULTS environment variable, then the home and current directory
+// search of find_defaults_file
+func resolve_defaults_file(config string, env string, log *logrus.Logger) (string, error) {
+
+	var err error
+
+	if config != "" {
+		log.Debugf("Using the defaults file from -config")
+		return config, nil
+	}
+
+	if env != "" {
+		_, err = os.Stat(env)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Error: XKCD_DEFAULTS points at %v: %v", env, err))
+		}
+		log.Debugf("Using the defaults file from XKCD_DEFAULTS")
+		return env, nil
+	}
+
+	return find_defaults_file(log)
+}
+
+// Returns the defaults file of the named profile, which is kept as
+// <name>.json in the xkcd-passwd directory of the user configuration
+// directory.  A missing profile is an error listing the ones there are.
+func profile_file(name string) (string, error) {
+
+	var (
+		configDir string
+		filename string
+		matches []string
+		profiles []string
+		err error
+	)
+
+	configDir, err = userConfigDir()
+	if err != nil {
+		return "", err
+	}
+	configDir = filepath.Join(configDir, "xkcd-passwd")
+
+	filename = filepath.Join(configDir, name + ".json")
+	_, err = os.Stat(filename)
+	if err == nil {
+		return filename, nil
+	}
+
+	matches, _ = filepath.Glob(filepath.Join(configDir, "*.json"))
+	for _, match := range matches {
+		profiles = append(profiles, strings.TrimSuffix(filepath.Base(match), ".json"))
+	}
+	if len(profiles) == 0 {
+		return "", errors.New(fmt.Sprintf("Error: There is no profile %v, and no profiles in %v", name, configDir))
+	}
+
+	return "", errors.New(fmt.Sprintf("Error: There is no profile %v in %v, the profiles are %v", name, configDir, strings.Join(profiles, ", ")))
+}
+
+// The built in defaults, used when no defaults file should be read.  These
+// match xkcd-defaults1.json.
+func default_defaults() Defaults {
+
+	var symbols = []string{ "!", "@", "$", "%", "^", "&", "*", "-", "_", "+", "=", ":", "|", "~", "?", "/", ".", ";" }
+
+	return Defaults{
+		NumWords:			3,
+		WordLengthMin:			4,
+		WordLengthMax:			8,
+		CaseTransform:			CaseCapitalise,
+		SeparatorCharacter:		SeparatorRandom,
+		SeparatorAlphabet:		symbols,
+		PaddingDigitsBefore:		4,
+		PaddingDigitsAfter:		5,
+		PaddingType:			PaddingFixed,
+		PaddingCharacter:		PaddingRandom,
+		SymbolAlphabet:			symbols,
+		PaddingCharactersBefore:	2,
+		PaddingCharactersAfter:		3,
+		UppercaseRatio:			0.5,
+		MinWordVariety:			defaultMinWordVariety,
+		Log:				discard_logger(),
+		Random:				rand.Reader,
+	}
+}
+
+// Returns where each resolved field came from: "flag" when a command line
+// option changed it from base, the defaults after loading, otherwise
+// loadedFrom ("file" or "preset") when the loaded JSON set it, otherwise
+// "default"
+func defaults_provenance(base Defaults, fileKeys map[string]bool, loadedFrom string, resolved Defaults) (map[string]string, error) {
+
+	var (
+		baseFields map[string]json.RawMessage
+		resolvedFields map[string]json.RawMessage
+		jsonData []byte
+		provenance map[string]string
+		err error
+	)
+
+	jsonData, err = json.Marshal(to_json_defaults(base))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(jsonData, &baseFields)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err = json.Marshal(to_json_defaults(resolved))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(jsonData, &resolvedFields)
+	if err != nil {
+		return nil, err
+	}
+
+	provenance = make(map[string]string, len(resolvedFields))
+	for key, value := range resolvedFields {
+		if !bytes.Equal(value, baseFields[key]) {
+			provenance[key] = "flag"
+		} else if fileKeys[key] {
+			provenance[key] = loadedFrom
+		} else {
+			provenance[key] = "default"
+		}
+	}
+
+	return provenance, nil
+}
+
+// Logs every resolved field with where it came from, as in
+// num_words=4 (from flag)
+func log_provenance(defaults Defaults, provenance map[string]string) {
+
+	var (
+		fields map[string]json.RawMessage
+		jsonData []byte
+		keys []string
+	)
+
+	jsonData, _ = json.Marshal(to_json_defaults(defaults))
+	json.Unmarshal(jsonData, &fields)
+
+	for key := range provenance {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		defaults.Log.Debugf("%v=%s (from %v)", key, fields[key], provenance[key])
+	}
+}
+
+// Prints the resolved defaults and the size of the dictionary as JSON
+func write_dry_run(out io.Writer, defaults Defaults) error {
+
+	var (
+		jsonData []byte
+		err error
+	)
+
+	jsonData, err = json.MarshalIndent(JSON_DryRun{
+		JSON_Defaults:	to_json_defaults(defaults),
+		DictionarySize:	len(defaults.WordDictionary),
+	}, "", " ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "%s\n", jsonData)
+
+	return err
+}
+
+// Combines -debug with the deprecated -shouldDebug true|false, which only
+// counts when it was given
+func parse_debug(debug bool, legacy string, legacySet bool) (bool, error) {
+
+	if !legacySet {
+		return debug, nil
+	}
+
+	switch strings.ToLower(legacy) {
+	case "true":
+		return true, nil
+	case "false":
+		return debug, nil
+	default:
+		return false, errors.New(fmt.Sprintf("Error: shouldDebug is not true/false (%s)", legacy))
+	}
+}
+
+// An explicit -log-level wins, otherwise -debug turns on everything and
+// only warnings and errors are shown by default
+func parse_log_level(value string, valueSet bool, debug bool) (logrus.Level, error) {
+
+	var (
+		level logrus.Level
+		err error
+	)
+
+	if !valueSet {
+		if debug {
+			return logrus.DebugLevel, nil
+		}
+		return logrus.WarnLevel, nil
+	}
+
+	level, err = logrus.ParseLevel(value)
+	if err != nil {
+		return logrus.WarnLevel, errors.New(fmt.Sprintf("Error: Unknown log level: %v", value))
+	}
+
+	return level, nil
+}
+
+// Writes log entries as "level: message", followed by any fields, so that
+// a warning reads like any other command line tool's rather than as a
+// timestamped logrus line
+type plainFormatter struct{}
+
+func (plainFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+
+	var (
+		buffer bytes.Buffer
+		keys []string
+	)
+
+	fmt.Fprintf(&buffer, "%v: %v", entry.Level, strings.TrimRight(entry.Message, "\n"))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&buffer, " %v=%v", key, entry.Data[key])
+	}
+	buffer.WriteByte('\n')
+
+	return buffer.Bytes(), nil
+}
+
+// Splits off the subcommand:
+//   generate               - generates passwords, the default
+//   config print           - prints the resolved defaults, like -dry-run
+//   config validate        - validates the resolved defaults, like -validate
+//   entropy                - prints the entropy of the resolved defaults
+// Without a subcommand the arguments are those of generate.
+func parse_subcommand(args []string) (string, []string, error) {
+
+	if len(args) == 0 {
+		return "generate", args, nil
+	}
+
+	// completion is left out of the usage on purpose
+	switch args[0] {
+	case "generate", "entropy", "completion":
+		return args[0], args[1:], nil
+	case "config":
+		if len(args) < 2 || (args[1] != "print" && args[1] != "validate") {
+			return "", nil, errors.New("Error: config needs print or validate")
+		}
+		return "config " + args[1], args[2:], nil
+	}
+
+	return "generate", args, nil
+}
+
+// Short names for cases in a spec.  word-random has its dash, which splits
+// tokens, so wrand is the only way to give it.
+var specCases = map[string]string{
+	"cap":		"capitalise",
+	"alt":		"alternate",
+	"rand":		"random",
+	"wrand":	"word-random",
+}
+
+// Expands a spec such as 4w-cap-2d into the flags it stands for.  The spec
+// is tokens joined by dashes:
+//   <n>w                   - n words, like -words
+//   <n>d                   - n digits after the words, like -digits-after
+//   <n>b                   - n digits before the words, like -digits-before
+//   <case>                 - a case_transform name, or cap, alt, rand or wrand
+// Each flag may only be given once.
+func parse_spec(spec string) (map[string]string, error) {
+
+	var (
+		overrides map[string]string = make(map[string]string)
+		name string
+		value string
+		found bool
+	)
+
+	for _, token := range strings.Split(spec, "-") {
+		name = ""
+		if len(token) > 1 && strings.IndexByte("wdb", token[len(token)-1]) != -1 {
+			if _, err := strconv.Atoi(token[:len(token)-1]); err == nil {
+				switch token[len(token)-1] {
+				case 'w':	name = "words"
+				case 'd':	name = "digits-after"
+				case 'b':	name = "digits-before"
+				}
+				value = token[:len(token)-1]
+			}
+		}
+		if name == "" {
+			value, found = specCases[token]
+			if !found {
+				value = token
+			}
+			if _, err := parse_case_type(value); err != nil {
+				return nil, errors.New(fmt.Sprintf("Error: Unknown token %v in the spec %v", token, spec))
+			}
+			name = "case"
+		}
+		if _, found = overrides[name]; found {
+			return nil, errors.New(fmt.Sprintf("Error: The spec %v gives %v twice", spec, name))
+		}
+		overrides[name] = value
+	}
+
+	return overrides, nil
+}
+
+// A flag which may be given more than once, collecting every value
+type stringList []string
+
+func (list *stringList) String() string {
+
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+
+	*list = append(*list, value)
+
+	return nil
+}
+
+func is_flag_set(flags *flag.FlagSet, name string) bool {
+
+	var found bool = false
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+
+	return found
+}
+
+// Exit statuses, so that scripts can tell why a run failed
+const (
+	ExitOK			int = 0		// Success
+	ExitError		int = 1		// Reading or writing failed, such as the output file
+	ExitUsage		int = 2		// Bad arguments or options, as the flag package also exits with
+	ExitConfig		int = 3		// The defaults file is missing, unreadable or invalid
+	ExitDictionary		int = 4		// A word list is unreadable or no words are left in the dictionary
+	ExitImpossible		int = 5		// The constraints cannot be met, such as -min-entropy
+)
+
+func main() {
+
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// The command line after parsing: the subcommand, the flags and what they
+// work out to
+type Options struct {
+	Command			string
+	Flags			*flag.FlagSet
+	Args			[]string
+	Log			*logrus.Logger
+	Random			io.Reader	// crypto/rand, or the -seed stream
+	NumPasswords		int
+	Between			string		// Written between the passwords
+	Terminator		string		// Written after the last password
+	Dictionaries		stringList
+
+	// The flag values
+	Version			*bool
+	ShouldDebug		*string
+	Debug			*bool
+	LogLevel		*string
+	Words			*int
+	IndexPrefix		*bool
+	MinLength		*int
+	MaxLength		*int
+	DigitsBefore		*int
+	DigitsAfter		*int
+	Case			*string
+	Separator		*string
+	JSON			*bool
+	MaxIdenticalAdjacent	*int
+	Output			*string
+	Append			*bool
+	RenderSpaces		*bool
+	OutputFormat		*string
+	RenderOnlyLetters	*bool
+	NoDuplicateWords	*bool
+	CapitalizeBySyllable	*bool
+	Validate		*bool
+	SeparatePaddingDigits	*bool
+	AutoLength		*bool
+	MergeStrategy		*string
+	WordsSourcePriority	*bool
+	FilterCommonWeak	*bool
+	ExcludeWords		*string
+	WordRegex		*string
+	Choose			*int
+	Count			*int
+	Interactive		*bool
+	EntropySourceInfo	*bool
+	ShowEntropy		*bool
+	Estimate		*bool
+	EntropyBreakdown	*bool
+	Copy			*bool
+	AvoidAmbiguous		*bool
+	AvoidAmbiguousWords	*bool
+	AmbiguousCharacters	*string
+	NoTrailingNewline	*bool
+	Print0			*bool
+	PasswordSeparator	*string
+	Print			*bool
+	GuessRate		*float64
+	KeyboardLayout		*string
+	NoConfig		*bool
+	Config			*string
+	StrictConfig		*bool
+	DryRun			*bool
+	FuzzyLength		*string
+	LengthHistogram		*string
+	SeparatorPerPosition	*string
+	Leet			*bool
+	Mode			*string
+	DigitPlacement		*string
+	WordSelection		*string
+	MinWordVariety		*int
+	UppercaseRatio		*float64
+	Syllables		*int
+	Format			*string
+	BloomFile		*string
+	InjectSymbolProbability	*float64
+	SeparatorAlphabet	*string
+	SymbolAlphabet		*string
+	MinEntropy		*float64
+	Rate			*float64
+	Parallel		*int
+	Seed			*string
+	OnGenerate		*string
+	EntropyFloor		*float64
+	Preset			*string
+	Profile			*string
+	ListPresets		*bool
+}
+
+// Does everything main does against the given arguments, reader and
+// writers, returning the exit status rather than exiting so that deferred
+// functions run.  Nothing outside the call is changed, so it can be called
+// more than once, even at the same time.
+func run(arguments []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+
+	var (
+		logMain *logrus.Logger = &logrus.Logger{
+			Out: stderr,
+			Formatter: new(logrus.TextFormatter),
+			Level: logrus.DebugLevel,
+		}
+		options Options
+		defaults Defaults
+		done bool
+		status int
+	)
+
+	options, done, status = parse_options(arguments, stdout, stderr, logMain)
+	if done {
+		return status
+	}
+	defaults, done, status = load_defaults(options, stdin, stdout, stderr, logMain)
+	if done {
+		return status
+	}
+
+	return generate_output(options, defaults, stdin, stdout, stderr, logMain)
+}
+
+// Parses the subcommand and flags and checks how they are combined.  done
+// is set, along with the exit status, when there is nothing more to do:
+// after an error, -help, -version, -list-presets or completion.
+func parse_options(arguments []string, stdout io.Writer, stderr io.Writer, logMain *logrus.Logger) (Options, bool, int) {
+
+	var (
+		options Options = Options{
+			Random:		rand.Reader,
+			NumPasswords:	1,
+			Between:	"\n",
+			Terminator:	"\n",
+		}
+		flags *flag.FlagSet
+		commandArgs []string
+		debug bool
+		level logrus.Level
+		log *logrus.Logger
+		err error
+	)
+
+	// Each subcommand parses its own flags
+	options.Command, commandArgs, err = parse_subcommand(arguments)
+	if err != nil {
+		logMain.Error(err)
+		return options, true, ExitUsage
+	}
+	flags = flag.NewFlagSet(filepath.Base(os.Args[0]) + " " + options.Command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	options.Flags = flags
+
+	options.Version = flags.Bool("version", false, "Should output program version")
+	options.Debug = flags.Bool("debug", false, "Should output debug output")
+	options.LogLevel = flags.String("log-level", "warn", "Only log messages at this level or above (error, warn, info, debug)")
+	options.ShouldDebug = flags.String("shouldDebug", "false", "Deprecated, use -debug instead")
+	options.Words = flags.Int("words", 0, "Overrides num_words from the defaults file")
+	options.IndexPrefix = flags.Bool("index-prefix", false, "Should prefix each password with its index")
+	options.MinLength = flags.Int("min-length", 0, "Overrides word_length_min from the defaults file")
+	options.MaxLength = flags.Int("max-length", 0, "Overrides word_length_max from the defaults file")
+	options.DigitsBefore = flags.Int("digits-before", 0, "Overrides padding_digits_before from the defaults file")
+	options.DigitsAfter = flags.Int("digits-after", 0, "Overrides padding_digits_after from the defaults file")
+	options.Case = flags.String("case", "", "Overrides case_transform from the defaults file")
+	options.Separator = flags.String("separator", "", "Overrides separator_character from the defaults file")
+	options.JSON = flags.Bool("json", false, "Should output the passwords as a JSON array")
+	options.SeparatorAlphabet = flags.String("separator-alphabet", "", "Overrides separator_alphabet from the defaults file and picks separators randomly from it")
+	options.SymbolAlphabet = flags.String("symbol-alphabet", "", "Overrides symbol_alphabet from the defaults file and picks padding randomly from it")
+	options.InjectSymbolProbability = flags.Float64("inject-symbol-probability", 0, "Overrides inject_symbol_probability from the defaults file")
+	options.BloomFile = flags.String("bloom-file", "", "Avoid passwords generated by earlier runs which used this bloom filter file")
+	options.Format = flags.String("format", "", "Lay out each password with this text/template instead of the default order")
+	options.UppercaseRatio = flags.Float64("uppercase-ratio", 0.5, "Overrides uppercase_ratio, the probability of each character being uppercase with the random case, from the defaults file")
+	options.MinWordVariety = flags.Int("min-word-variety", defaultMinWordVariety, "Overrides min_word_variety, the dictionary words wanted for each word in the password, from the defaults file")
+	options.DigitPlacement = flags.String("digit-placement", "", "Overrides digit_placement from the defaults file (both, before, after, between-all, random-gap)")
+	options.WordSelection = flags.String("word-selection", "", "Overrides word_selection from the defaults file (uniform, length)")
+	options.Mode = flags.String("mode", "", "Overrides mode from the defaults file (dictionary, syllable)")
+	options.Syllables = flags.Int("syllables", 0, "Overrides syllables_per_word from the defaults file")
+	options.Leet = flags.Bool("leet", false, "Should substitute letters like a with @, with leet_probability or else half of the time")
+	options.SeparatorPerPosition = flags.String("separator-per-position", "", "Overrides separator_sequence from the defaults file, the separators between words in order")
+	options.LengthHistogram = flags.String("length-histogram", "", "Pad or truncate passwords to lengths drawn from the histogram length:weight,...")
+	options.FuzzyLength = flags.String("fuzzy-length", "", "Pad or truncate every password to a random length in the range min-max")
+	options.DryRun = flags.Bool("dry-run", false, "Should print the resolved defaults as JSON and exit")
+	options.StrictConfig = flags.Bool("strict-config", false, "Should treat unknown fields in the defaults file as errors")
+	options.Config = flags.String("config", "", "Read the defaults from this file instead of searching for .xkcd-defaults.json")
+	options.Profile = flags.String("profile", "", "Use the named profile, xkcd-passwd/<name>.json in the user configuration directory, as the defaults file")
+	options.Preset = flags.String("preset", "", "Use the named preset instead of a defaults file, see -list-presets")
+	options.ListPresets = flags.Bool("list-presets", false, "Should list the presets and exit")
+	options.NoConfig = flags.Bool("no-config", false, "Should ignore any .xkcd-defaults.json and use the built in defaults")
+	options.KeyboardLayout = flags.String("keyboard-layout", "", "Only use symbols easily typed on this keyboard layout (us, uk, de, fr)")
+	options.NoTrailingNewline = flags.Bool("no-trailing-newline", false, "Should leave the newline off the last password")
+	options.PasswordSeparator = flags.String("password-separator", "\\n", "Write this between the passwords, with escapes like \\t, instead of a newline")
+	options.Print0 = flags.Bool("print0", false, "Should end every password with a null byte instead of a newline, for xargs -0")
+	options.AvoidAmbiguous = flags.Bool("avoid-ambiguous", false, "Should leave ambiguous characters like l, 1 and I out of the separators, padding symbols and digits")
+	options.AvoidAmbiguousWords = flags.Bool("avoid-ambiguous-words", false, "Should also leave out the dictionary words with ambiguous letters")
+	options.AmbiguousCharacters = flags.String("ambiguous-characters", "", "Overrides the ambiguous characters (ambiguous_characters) from the defaults file")
+	options.Copy = flags.Bool("copy", false, "Should copy the password to the clipboard instead of printing it")
+	options.Print = flags.Bool("print", false, "Should print the password as well with -copy")
+	options.EntropyBreakdown = flags.Bool("entropy-breakdown", false, "Should output the entropy of each part of the passwords as JSON and exit")
+	options.Estimate = flags.Bool("estimate", false, "Should output an estimate of how long guessing the passwords takes")
+	options.GuessRate = flags.Float64("guess-rate", 1e10, "The guesses per second assumed by -estimate")
+	options.ShowEntropy = flags.Bool("show-entropy", false, "Should output the entropy of the passwords")
+	options.EntropyFloor = flags.Float64("entropy-floor", 0, "Only output passwords with at least this many bits of entropy, strongest first")
+	options.OnGenerate = flags.String("on-generate", "", "Run this shell command for every password, which is given to it on stdin")
+	options.Seed = flags.String("seed", "", "Use a deterministic random stream from this hex seed, for reproducing output only; NOT cryptographically secure")
+	options.Parallel = flags.Int("parallel", 0, "Generate the passwords using this many workers")
+	options.Rate = flags.Float64("rate", 0, "Generate at most this many passwords per second, writing each as it is generated")
+	options.MinEntropy = flags.Float64("min-entropy", 0, "Refuse to generate passwords with fewer bits of entropy than this")
+	options.EntropySourceInfo = flags.Bool("entropy-source-info", false, "Should report which entropy source is in use")
+	options.Interactive = flags.Bool("interactive", false, "Should generate another password every time Enter is pressed")
+	options.Count = flags.Int("count", 1, "Generate this many passwords, the same as the number argument")
+	options.Choose = flags.Int("choose", 0, "Generate this many candidates and choose one interactively")
+	options.WordRegex = flags.String("word-regex", "", "Only use dictionary words which match this regular expression")
+	options.ExcludeWords = flags.String("exclude-words", "", "Remove the words in this file from the dictionary")
+	options.FilterCommonWeak = flags.Bool("filter-common-weak", false, "Should remove words which are common weak passwords from the dictionary")
+	flags.Var(&options.Dictionaries, "dictionary", "Use the word list in this file or at this URL instead of the built in dictionary, may be repeated")
+	options.MergeStrategy = flags.String("merge-strategy", "union", "How to merge repeated options.Dictionaries (union, intersect, concat)")
+	options.WordsSourcePriority = flags.Bool("words-source-priority", false, "Should use the first -dictionary with words within the length bounds rather than merging them")
+	options.AutoLength = flags.Bool("auto-length", false, "Should set the word length bounds from the dictionary")
+	options.SeparatePaddingDigits = flags.Bool("separate-padding-digits", false, "Overrides separate_padding_digits from the defaults file")
+	options.Validate = flags.Bool("validate", false, "Should only validate the defaults, including any overrides, and exit")
+	options.CapitalizeBySyllable = flags.Bool("words-capitalize-by-syllable", false, "Should uppercase the first letter of every syllable, the same as -case syllable")
+	options.NoDuplicateWords = flags.Bool("no-duplicate-words", false, "Overrides no_duplicate_words from the defaults file")
+	options.RenderOnlyLetters = flags.Bool("render-only-letters", false, "Should strip everything but letters from each word")
+	options.RenderSpaces = flags.Bool("render-spaces", false, "Should use a single space as the separator")
+	options.OutputFormat = flags.String("output-format", "plain", "How to write each password (plain, shell, env)")
+	options.Output = flags.String("output", "", "Write the passwords to this file instead of stdout")
+	options.Append = flags.Bool("append", false, "Should add the passwords to the end of the output file instead of replacing it")
+	options.MaxIdenticalAdjacent = flags.Int("max-identical-adjacent-chars", 0, "Overrides max_identical_adjacent from the defaults file")
+
+	err = flags.Parse(commandArgs)
+	if err == flag.ErrHelp {
+		return options, true, ExitOK
+	} else if err != nil {
+		return options, true, ExitUsage
+	}
+	options.Args = flags.Args()
+
+	switch options.Command {
+	case "config print":
+		*options.DryRun = true
+	case "config validate":
+		*options.Validate = true
+	case "completion":
+		if len(options.Args) != 1 {
+			logMain.Error("Error: completion needs a shell, bash or zsh")
+			return options, true, ExitUsage
+		}
+		err = write_completion(stdout, options.Args[0], filepath.Base(os.Args[0]), flags)
+		if err != nil {
+			logMain.Error(err)
+			return options, true, ExitUsage
+		}
+		return options, true, ExitOK
+	}
+
+	// A spec comes before the number of passwords, as in 4w-cap-2d 5
+	if len(options.Args) > 0 {
+		if _, err = strconv.Atoi(options.Args[0]); err != nil {
+			var overrides map[string]string
+			overrides, err = parse_spec(options.Args[0])
+			if err != nil {
+				logMain.Error("Error parsing spec: ", err)
+				return options, true, ExitUsage
+			}
+			for name := range overrides {
+				if is_flag_set(flags, name) {
+					logMain.Error(fmt.Sprintf("Error: The spec %v and -%v cannot be used together", options.Args[0], name))
+					return options, true, ExitUsage
+				}
+			}
+			for name, value := range overrides {
+				flags.Set(name, value)
+			}
+			options.Args = options.Args[1:]
+		}
+	}
+
+	if *options.Version {
+		fmt.Fprintln(stdout, "version =", version)
+		fmt.Fprintln(stdout, "release =", release)
+		return options, true, ExitOK
+	}
+
+	debug, err = parse_debug(*options.Debug, *options.ShouldDebug, is_flag_set(flags, "shouldDebug"))
+	if err != nil {
+		logMain.Error(err)
+		return options, true, ExitUsage
+	}
+
+	level, err = parse_log_level(*options.LogLevel, is_flag_set(flags, "log-level"), debug)
+	if err != nil {
+		logMain.Error(err)
+		return options, true, ExitUsage
+	}
+	log = &logrus.Logger{
+		Out: stderr,
+		Formatter: plainFormatter{},
+		Level: level,
+	}
+	options.Log = log
+
+	log.Debugf("flag.Args = %v\n", options.Args)
+	if len(options.Args) == 1 {
+		options.NumPasswords, err = strconv.Atoi(options.Args[0])
+		if err != nil {
+			logMain.Error("Error during strconv.Atoi: ", err)
+			return options, true, ExitUsage
+		}
+	} else if len(options.Args) != 0 {
+		logMain.Error(fmt.Sprintf("Error: Only one argument is allowed\n"))
+		return options, true, ExitUsage
+	}
+	if is_flag_set(flags, "count") {
+		if *options.Count < 1 {
+			logMain.Error(fmt.Sprintf("Error: count must be at least 1 (%d)\n", *options.Count))
+			return options, true, ExitUsage
+		}
+		if len(options.Args) == 1 && options.NumPasswords != *options.Count {
+			logMain.Error(fmt.Sprintf("Error: count (%d) and the number of passwords (%d) differ\n", *options.Count, options.NumPasswords))
+			return options, true, ExitUsage
+		}
+		options.NumPasswords = *options.Count
+	}
+
+	if is_flag_set(flags, "rate") {
+		if *options.Rate <= 0 {
+			logMain.Error(fmt.Sprintf("Error: rate must be positive (%v)\n", *options.Rate))
+			return options, true, ExitUsage
+		}
+		if *options.JSON || is_flag_set(flags, "choose") || is_flag_set(flags, "entropy-floor") {
+			logMain.Error("Error: rate cannot be used with json, choose or entropy-floor")
+			return options, true, ExitUsage
+		}
+	}
+
+	if is_flag_set(flags, "parallel") {
+		if *options.Parallel < 1 {
+			logMain.Error(fmt.Sprintf("Error: parallel must be at least 1 (%d)\n", *options.Parallel))
+			return options, true, ExitUsage
+		}
+		if is_flag_set(flags, "rate") || *options.BloomFile != "" {
+			logMain.Error("Error: parallel cannot be used with rate or bloom-file")
+			return options, true, ExitUsage
+		}
+	}
+
+	if *options.Interactive && (len(options.Args) != 0 || is_flag_set(flags, "count") || is_flag_set(flags, "choose") || *options.JSON || is_flag_set(flags, "rate") || *options.Output != "") {
+		logMain.Error("Error: interactive cannot be used with a number of passwords, choose, json, rate or output")
+		return options, true, ExitUsage
+	}
+
+	if is_flag_set(flags, "choose") {
+		if *options.Choose < 1 {
+			logMain.Error(fmt.Sprintf("Error: choose must be at least 1 (%d)\n", *options.Choose))
+			return options, true, ExitUsage
+		}
+		if len(options.Args) != 0 || is_flag_set(flags, "count") {
+			logMain.Error("Error: choose cannot be used with a number of passwords")
+			return options, true, ExitUsage
+		}
+		options.NumPasswords = *options.Choose
+	}
+
+	if *options.Copy && ((options.NumPasswords != 1 && !is_flag_set(flags, "choose")) || *options.JSON || is_flag_set(flags, "rate") || *options.Interactive) {
+		logMain.Error("Error: copy needs a single password, or choose, and cannot be used with json, rate or interactive")
+		return options, true, ExitUsage
+	}
+	if *options.NoTrailingNewline {
+		if *options.Print0 || *options.JSON || is_flag_set(flags, "rate") {
+			logMain.Error("Error: no-trailing-newline cannot be used with print0, json or rate")
+			return options, true, ExitUsage
+		}
+		options.Terminator = ""
+	}
+	if *options.Print0 {
+		if *options.JSON || *options.Interactive {
+			logMain.Error("Error: print0 cannot be used with json or interactive")
+			return options, true, ExitUsage
+		}
+		options.Terminator = "\x00"
+		options.Between = "\x00"
+	}
+	// Ignored with -json
+	if is_flag_set(flags, "password-separator") {
+		if *options.Print0 || *options.Interactive || is_flag_set(flags, "rate") {
+			logMain.Error("Error: password-separator cannot be used with print0, interactive or rate")
+			return options, true, ExitUsage
+		}
+		options.Between, err = strconv.Unquote("\"" + *options.PasswordSeparator + "\"")
+		if err != nil {
+			logMain.Error(fmt.Sprintf("Error: password-separator has an invalid escape (%v)", *options.PasswordSeparator))
+			return options, true, ExitUsage
+		}
+	}
+	// The fallback depends on the length bounds, which auto-length sets
+	// from the dictionary
+	if *options.WordsSourcePriority && (is_flag_set(flags, "merge-strategy") || *options.AutoLength) {
+		logMain.Error("Error: words-source-priority cannot be used with merge-strategy or auto-length")
+		return options, true, ExitUsage
+	}
+	if is_flag_set(flags, "output-format") {
+		if _, err = format_passwords([]string{ "" }, *options.OutputFormat); err != nil {
+			logMain.Error("Error parsing output-format: ", err)
+			return options, true, ExitUsage
+		}
+		if *options.OutputFormat != "plain" && (*options.JSON || is_flag_set(flags, "rate") || *options.Interactive || *options.IndexPrefix) {
+			logMain.Error("Error: output-format of shell or env cannot be used with json, rate, interactive or index-prefix")
+			return options, true, ExitUsage
+		}
+	}
+	if *options.Append && (*options.Output == "" || *options.JSON) {
+		logMain.Error("Error: append needs output and cannot be used with json")
+		return options, true, ExitUsage
+	}
+	if *options.Print && !*options.Copy {
+		logMain.Error("Error: print is only used with copy")
+		return options, true, ExitUsage
+	}
+
+	log.Debugf("version = %v\nrelease = %v\n", version, release)
+
+	if *options.GuessRate <= 0 {
+		logMain.Error(fmt.Sprintf("Error: guess-rate must be positive (%g)\n", *options.GuessRate))
+		return options, true, ExitUsage
+	}
+
+	if *options.Seed != "" {
+		var seeded *SeededReader
+		// Workers would race for the seeded stream, so the passwords would
+		// not be reproducible
+		if *options.Parallel > 1 {
+			log.Warnf("Generating the passwords with one worker instead of %d, to keep the seeded output reproducible", *options.Parallel)
+			*options.Parallel = 1
+		}
+		seeded, err = new_seeded_reader(*options.Seed)
+		if err != nil {
+			logMain.Error("Error parsing seed: ", err)
+			return options, true, ExitUsage
+		}
+		options.Random = seeded
+		fmt.Fprintln(stderr, "WARNING: -seed makes the output reproducible by anyone who knows the seed. These passwords are NOT cryptographically secure; only use them to verify output.")
+	}
+
+	if *options.EntropySourceInfo {
+		fmt.Fprintln(stderr, entropy_source_info(options.Random))
+	}
+
+	if *options.ListPresets {
+		list_presets(stdout)
+		return options, true, ExitOK
+	}
+
+	if *options.NoConfig && *options.Config != "" {
+		logMain.Error("Error: no-config cannot be used with config")
+		return options, true, ExitUsage
+	}
+	if *options.Preset != "" && (*options.NoConfig || *options.Config != "") {
+		logMain.Error("Error: preset cannot be used with config or no-config")
+		return options, true, ExitUsage
+	}
+	if *options.Profile != "" && (*options.NoConfig || *options.Config != "" || *options.Preset != "") {
+		logMain.Error("Error: profile cannot be used with config, no-config or preset")
+		return options, true, ExitUsage
+	}
+	// Both of these read stdin too
+	if *options.Config == "-" && (*options.Interactive || is_flag_set(flags, "choose")) {
+		logMain.Error("Error: config - cannot be used with interactive or choose")
+		return options, true, ExitUsage
+	}
+
+	return options, false, ExitOK
+}
+
+// Resolves the defaults from the preset, profile or defaults file and the
+// flags, loads the dictionary and validates the result.  done is set, along
+// with the exit status, after an error, -dry-run or -validate.
+func load_defaults(options Options, stdin io.Reader, stdout io.Writer, stderr io.Writer, logMain *logrus.Logger) (Defaults, bool, int) {
+
+	var (
+		log *logrus.Logger = options.Log
+		defaultFilename string
+		jsonData []byte
+		defaults Defaults
+		loadedDefaults Defaults
+		fileKeys map[string]bool = map[string]bool{}
+		loadedFrom string = "file"
+		err error
+	)
+
+	if *options.NoConfig {
+		defaults = default_defaults()
+	} else {
+		if *options.Preset != "" {
+			jsonData, err = find_preset(*options.Preset)
+			if err != nil {
+				logMain.Error("Error loading preset: ", err)
+				return defaults, true, ExitUsage
+			}
+			log.Infof("Using preset %v", strings.ToUpper(*options.Preset))
+			loadedFrom = "preset"
+		} else {
+			if *options.Profile != "" {
+				defaultFilename, err = profile_file(*options.Profile)
+			} else {
+				defaultFilename, err = resolve_defaults_file(*options.Config, os.Getenv("XKCD_DEFAULTS"), log)
+			}
+			if err != nil {
+				logMain.Error("Error finding the defaults file: ", err)
+				return defaults, true, ExitConfig
+			}
+			log.Infof("Using defaults file %v", defaultFilename)
+
+			// Read the .xkcd-defaults.json file, or stdin for -config -
+			if defaultFilename == "-" {
+				jsonData, err = ioutil.ReadAll(stdin)
+			} else {
+				jsonData, err = ioutil.ReadFile(defaultFilename)
+			}
+			if err != nil {
+				logMain.Error("Error when opening .xkcd-defaults.json: ", err)
+				return defaults, true, ExitConfig
+			}
+		}
+
+		// Return the default struct from the file data
+		defaults, err = read_defaults(jsonData, *options.StrictConfig)
+		if err != nil {
+			logMain.Error("Error reading defaults: ", err)
+			return defaults, true, ExitConfig
+		}
+
+		// Remember which fields the file set, for log_provenance
+		var fileFields map[string]json.RawMessage
+		json.Unmarshal(jsonData, &fileFields)
+		for key := range fileFields {
+			fileKeys[key] = true
+		}
+	}
+	// Where generating logs to and draws from
+	defaults.Log = log
+	defaults.Random = options.Random
+	loadedDefaults = defaults
+
+	// Command line overrides take precedence over the defaults file
+	if is_flag_set(options.Flags, "words") {
+		if *options.Words < 1 {
+			logMain.Error(fmt.Sprintf("Error: words must be at least 1 (%d)\n", *options.Words))
+			return defaults, true, ExitUsage
+		}
+		defaults.NumWords = *options.Words
+	}
+	if is_flag_set(options.Flags, "min-length") {
+		if *options.MinLength < 1 {
+			logMain.Error(fmt.Sprintf("Error: min-length must be at least 1 (%d)\n", *options.MinLength))
+			return defaults, true, ExitUsage
+		}
+		defaults.WordLengthMin = *options.MinLength
+	}
+	if is_flag_set(options.Flags, "max-length") {
+		if *options.MaxLength < 1 {
+			logMain.Error(fmt.Sprintf("Error: max-length must be at least 1 (%d)\n", *options.MaxLength))
+			return defaults, true, ExitUsage
+		}
+		defaults.WordLengthMax = *options.MaxLength
+	}
+	if is_flag_set(options.Flags, "digits-before") {
+		if *options.DigitsBefore < 0 {
+			logMain.Error(fmt.Sprintf("Error: digits-before must not be negative (%d)\n", *options.DigitsBefore))
+			return defaults, true, ExitUsage
+		}
+		defaults.PaddingDigitsBefore = *options.DigitsBefore
+	}
+	if is_flag_set(options.Flags, "digits-after") {
+		if *options.DigitsAfter < 0 {
+			logMain.Error(fmt.Sprintf("Error: digits-after must not be negative (%d)\n", *options.DigitsAfter))
+			return defaults, true, ExitUsage
+		}
+		defaults.PaddingDigitsAfter = *options.DigitsAfter
+	}
+	if is_flag_set(options.Flags, "case") {
+		defaults.CaseTransform, err = parse_case_type(*options.Case)
+		if err != nil {
+			logMain.Error("Error parsing case: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if is_flag_set(options.Flags, "separator") {
+		defaults.SeparatorCharacter, defaults.SeparatorAlphabet, err = parse_separator_character(*options.Separator, defaults.SeparatorAlphabet)
+		if err != nil {
+			logMain.Error("Error parsing separator: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if is_flag_set(options.Flags, "separator-alphabet") {
+		if is_flag_set(options.Flags, "separator") {
+			logMain.Error("Error: separator-alphabet and separator cannot be used together")
+			return defaults, true, ExitUsage
+		}
+		defaults.SeparatorAlphabet, err = parse_alphabet(*options.SeparatorAlphabet)
+		if err != nil {
+			logMain.Error("Error parsing separator-alphabet: ", err)
+			return defaults, true, ExitUsage
+		}
+		defaults.SeparatorCharacter = SeparatorRandom
+		// The weights were for the old alphabet
+		defaults.SeparatorWeights = nil
+	}
+	if is_flag_set(options.Flags, "symbol-alphabet") {
+		defaults.SymbolAlphabet, err = parse_alphabet(*options.SymbolAlphabet)
+		if err != nil {
+			logMain.Error("Error parsing symbol-alphabet: ", err)
+			return defaults, true, ExitUsage
+		}
+		defaults.PaddingCharacter = PaddingRandom
+	}
+	if *options.RenderSpaces {
+		if is_flag_set(options.Flags, "separator") {
+			logMain.Error("Error: render-spaces and separator cannot be used together")
+			return defaults, true, ExitUsage
+		}
+		defaults.SeparatorCharacter = SeparatorCharacter
+		defaults.SeparatorAlphabet = []string{ " " }
+	}
+	if is_flag_set(options.Flags, "separate-padding-digits") {
+		defaults.SeparatePaddingDigits = *options.SeparatePaddingDigits
+	}
+	if is_flag_set(options.Flags, "ambiguous-characters") {
+		defaults.AmbiguousCharacters = *options.AmbiguousCharacters
+	}
+	if is_flag_set(options.Flags, "min-word-variety") {
+		defaults.MinWordVariety = *options.MinWordVariety
+	}
+	if is_flag_set(options.Flags, "uppercase-ratio") {
+		defaults.UppercaseRatio = *options.UppercaseRatio
+	}
+	if *options.DigitPlacement != "" {
+		defaults.DigitPlacement, err = parse_digit_placement(*options.DigitPlacement)
+		if err != nil {
+			logMain.Error("Error parsing digit-placement: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if *options.WordSelection != "" {
+		defaults.WordSelection, err = parse_word_selection(*options.WordSelection)
+		if err != nil {
+			logMain.Error("Error parsing word-selection: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if *options.Mode != "" {
+		defaults.Mode, err = parse_word_mode(*options.Mode)
+		if err != nil {
+			logMain.Error("Error parsing mode: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if is_flag_set(options.Flags, "syllables") {
+		defaults.SyllablesPerWord = *options.Syllables
+	} else if defaults.Mode == WordsSyllable && defaults.SyllablesPerWord == 0 {
+		defaults.SyllablesPerWord = 3
+	}
+	if *options.Leet && defaults.LeetProbability == 0 {
+		defaults.LeetProbability = 0.5
+	}
+	if *options.SeparatorPerPosition != "" {
+		defaults.SeparatorSequence, err = parse_alphabet(*options.SeparatorPerPosition)
+		if err != nil {
+			logMain.Error("Error parsing separator-per-position: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if *options.LengthHistogram != "" {
+		if *options.FuzzyLength != "" {
+			logMain.Error("Error: length-histogram and fuzzy-length cannot be used together")
+			return defaults, true, ExitUsage
+		}
+		defaults.HistogramLengths, defaults.HistogramWeights, err = parse_length_histogram_flag(*options.LengthHistogram)
+		if err != nil {
+			logMain.Error("Error parsing length-histogram: ", err)
+			return defaults, true, ExitUsage
+		}
+		defaults.MinTotalLength = 0
+		defaults.MaxTotalLength = 0
+		defaults.PaddingType = PaddingAdaptive
+		err = validate_ranges(defaults)
+		if err != nil {
+			logMain.Error("Error parsing length-histogram: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if *options.FuzzyLength != "" {
+		defaults.MinTotalLength, defaults.MaxTotalLength, err = parse_length_range(*options.FuzzyLength)
+		if err != nil {
+			logMain.Error("Error parsing fuzzy-length: ", err)
+			return defaults, true, ExitUsage
+		}
+		defaults.HistogramLengths = nil
+		defaults.HistogramWeights = nil
+		defaults.PaddingType = PaddingAdaptive
+	}
+	if *options.CapitalizeBySyllable {
+		if is_flag_set(options.Flags, "case") {
+			logMain.Error("Error: words-capitalize-by-syllable and case cannot be used together")
+			return defaults, true, ExitUsage
+		}
+		defaults.CaseTransform = CaseSyllable
+	}
+	if is_flag_set(options.Flags, "no-duplicate-words") {
+		defaults.NoDuplicateWords = *options.NoDuplicateWords
+	}
+	if *options.RenderOnlyLetters {
+		defaults.OnlyLetters = true
+	}
+	if is_flag_set(options.Flags, "inject-symbol-probability") {
+		defaults.InjectSymbolProbability = *options.InjectSymbolProbability
+	}
+	if is_flag_set(options.Flags, "max-identical-adjacent-chars") {
+		if *options.MaxIdenticalAdjacent < 0 {
+			logMain.Error(fmt.Sprintf("Error: max-identical-adjacent-chars must not be negative (%d)\n", *options.MaxIdenticalAdjacent))
+			return defaults, true, ExitUsage
+		}
+		defaults.MaxIdenticalAdjacent = *options.MaxIdenticalAdjacent
+	}
+	// After the overrides, so that these also restrict the separators,
+	// symbols and leet substitutions they set
+	if *options.KeyboardLayout != "" {
+		defaults, err = apply_keyboard_layout(defaults, *options.KeyboardLayout)
+		if err != nil {
+			logMain.Error("Error applying keyboard layout: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	if *options.AvoidAmbiguous || *options.AvoidAmbiguousWords {
+		defaults, err = apply_avoid_ambiguous(defaults)
+		if err != nil {
+			logMain.Error("Error avoiding ambiguous characters: ", err)
+			return defaults, true, ExitImpossible
+		}
+	}
+	// The digits may have lost their ambiguous characters
+	if *options.Format != "" {
+		defaults.Format, err = parse_format(*options.Format, defaults)
+		if err != nil {
+			logMain.Error("Error parsing format: ", err)
+			return defaults, true, ExitUsage
+		}
+	}
+	log.Debugf("defaults: %+v\n", defaults)
+
+	if len(options.Dictionaries) > 0 {
+		var lists [][]string
+		for _, name := range options.Dictionaries {
+			var list []string
+			list, err = load_dictionary(name, log)
+			if err != nil {
+				logMain.Error("Error reading dictionary: ", err)
+				return defaults, true, ExitDictionary
+			}
+			log.Debugf("Dictionary %v has %d words", name, len(list))
+			lists = append(lists, list)
+		}
+		if *options.WordsSourcePriority {
+			var used int
+			defaults.WordDictionary, used = prioritise_dictionaries(defaults, lists)
+			if used < 0 {
+				logMain.Error(fmt.Sprintf("Error: None of the options.Dictionaries has words between %d and %d letters long", defaults.WordLengthMin, defaults.WordLengthMax))
+				return defaults, true, ExitDictionary
+			}
+			if used > 0 {
+				log.Infof("Using dictionary %v, since the ones before it have no words within the length bounds", options.Dictionaries[used])
+			}
+		} else {
+			defaults.WordDictionary, err = merge_dictionaries(lists, *options.MergeStrategy)
+			if err != nil {
+				logMain.Error("Error merging options.Dictionaries: ", err)
+				return defaults, true, ExitDictionary
+			}
+			log.Debugf("Merged %d options.Dictionaries (%v) into %d words", len(lists), *options.MergeStrategy, len(defaults.WordDictionary))
+			if len(defaults.WordDictionary) == 0 {
+				logMain.Error("Error: The options.Dictionaries have no words in common")
+				return defaults, true, ExitDictionary
+			}
+		}
+	} else {
+		defaults.WordDictionary = dictionary
+	}
+	if *options.FilterCommonWeak {
+		var size = len(defaults.WordDictionary)
+		defaults.WordDictionary = remove_words(defaults.WordDictionary, commonWeakWords)
+		log.Infof("filter-common-weak removed %d words", size - len(defaults.WordDictionary))
+		if len(defaults.WordDictionary) == 0 {
+			logMain.Error("Error: filter-common-weak removed every word from the dictionary")
+			return defaults, true, ExitDictionary
+		}
+	}
+	if *options.WordRegex != "" {
+		var size = len(defaults.WordDictionary)
+		defaults.WordDictionary, err = filter_words_by_regex(defaults.WordDictionary, *options.WordRegex)
+		if err != nil {
+			logMain.Error("Error applying word-regex: ", err)
+			return defaults, true, ExitUsage
+		}
+		log.Infof("word-regex removed %d words", size - len(defaults.WordDictionary))
+		if len(defaults.WordDictionary) == 0 {
+			logMain.Error("Error: word-regex removed every word from the dictionary")
+			return defaults, true, ExitDictionary
+		}
+	}
+	if *options.ExcludeWords != "" {
+		var excluded []string
+		var size = len(defaults.WordDictionary)
+		excluded, err = read_dictionary(*options.ExcludeWords)
+		if err != nil {
+			logMain.Error("Error reading exclude-words: ", err)
+			return defaults, true, ExitDictionary
+		}
+		defaults.WordDictionary = remove_words(defaults.WordDictionary, excluded)
+		log.Infof("exclude-words removed %d words", size - len(defaults.WordDictionary))
+		if len(defaults.WordDictionary) == 0 {
+			logMain.Error("Error: exclude-words removed every word from the dictionary")
+			return defaults, true, ExitDictionary
+		}
+	}
+	if *options.AvoidAmbiguousWords {
+		var size = len(defaults.WordDictionary)
+		defaults.WordDictionary = remove_ambiguous_words(defaults.WordDictionary, ambiguous_characters(defaults))
+		log.Infof("avoid-ambiguous-words removed %d words", size - len(defaults.WordDictionary))
+		if len(defaults.WordDictionary) == 0 {
+			logMain.Error("Error: avoid-ambiguous-words removed every word from the dictionary")
+			return defaults, true, ExitDictionary
+		}
+	}
+	log.Infof("len(WordDictionary) = %v\n", len(defaults.WordDictionary))
+
+	// Cover the middle half of the dictionary's word lengths
+	if *options.AutoLength {
+		if is_flag_set(options.Flags, "min-length") || is_flag_set(options.Flags, "max-length") {
+			logMain.Error("Error: auto-length cannot be used with min-length or max-length")
+			return defaults, true, ExitUsage
+		}
+		defaults.WordLengthMin = length_percentile(defaults.WordDictionary, 25)
+		defaults.WordLengthMax = length_percentile(defaults.WordDictionary, 75)
+		log.Infof("auto-length: WordLengthMin = %v, WordLengthMax = %v", defaults.WordLengthMin, defaults.WordLengthMax)
+	}
+
+	if log.IsLevelEnabled(logrus.DebugLevel) {
+		var provenance map[string]string
+		provenance, err = defaults_provenance(loadedDefaults, fileKeys, loadedFrom, defaults)
+		if err != nil {
+			logMain.Error("Error tracking provenance: ", err)
+			return defaults, true, ExitError
+		}
+		log_provenance(defaults, provenance)
+	}
+
+	if *options.DryRun {
+		err = write_dry_run(stdout, defaults)
+		if err != nil {
+			logMain.Error("Error writing defaults: ", err)
+			return defaults, true, ExitError
+		}
+		return defaults, true, ExitOK
+	}
+
+	err = validate_defaults(defaults)
+	// Likely mistakes rather than impossible settings, so only errors with
+	// -strict-config
+	for _, problem := range degenerate_padding(defaults) {
+		if *options.StrictConfig {
+			err = errors.Join(err, errors.New("Error: " + problem))
+		} else {
+			log.Warn(problem)
+		}
+	}
+	if runs_on(defaults) {
+		log.Warn("Nothing separates the words, so they run together; set separator_character or a case_transform such as capitalise to keep them readable")
+	}
+	if *options.Validate {
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return defaults, true, ExitConfig
+		}
+		return defaults, true, ExitOK
+	}
+	if err != nil {
+		logMain.Error("Error validating defaults: ", err)
+		return defaults, true, ExitConfig
+	}
+
+	// Filters such as -word-regex and -exclude-words can leave so few words
+	// that passwords often share them
+	if defaults.MinWordVariety > 0 {
+		var count int
+		count, _ = count_candidate_words(defaults)
+		if count < defaults.MinWordVariety * defaults.NumWords {
+			log.Warnf("Only %d dictionary words are within the length bounds, fewer than min_word_variety (%d) for each of the %d words, so passwords will often share words", count, defaults.MinWordVariety, defaults.NumWords)
+		}
+	}
+
+	if *options.WordRegex != "" {
+		var count int
+		count, _ = count_candidate_words(defaults)
+		if count < 1024 {
+			log.Warnf("word-regex leaves only %d words within the length bounds, under 10 bits of entropy per word", count)
+		}
+	}
+
+	return defaults, false, ExitOK
+}
+
+// Generates the passwords and writes them out, or copies them, along with
+// whatever the flags ask to report about them
+func generate_output(options Options, defaults Defaults, stdin io.Reader, stdout io.Writer, stderr io.Writer, logMain *logrus.Logger) int {
+
+	var (
+		log *logrus.Logger = options.Log
+		entropy float64
+		jsonData []byte
+		bloomFilter *BloomFilter
+		output io.Writer
+		outputFile *os.File
+		start time.Time
+		ctx context.Context
+		ticker *time.Ticker
+		generated []Password
+		password string
+		passwords []string
+		formatted []string
+		err error
+	)
+
+	entropy = calculate_entropy(defaults)
+	log.Infof("entropy = %v", entropy)
+
+	if options.Command == "entropy" {
+		fmt.Fprintf(stdout, "%.2f\n", entropy)
+		return ExitOK
+	}
+
+	if *options.EntropyBreakdown {
+		jsonData, err = json.MarshalIndent(entropy_breakdown(defaults), "", " ")
+		if err != nil {
+			logMain.Error("Error writing the entropy breakdown: ", err)
+			return ExitError
+		}
+		fmt.Fprintln(stdout, string(jsonData))
+		return ExitOK
+	}
+
+	// Every password from a given configuration has the same entropy, so
+	// this is a check of the configuration rather than a reason to retry
+	if entropy < *options.MinEntropy {
+		logMain.Error(fmt.Sprintf("Error: The configuration only provides %.2f bits of entropy, below the minimum of %.2f; regenerating cannot help, change the configuration instead", entropy, *options.MinEntropy))
+		return ExitImpossible
+	}
+
+	if *options.MinEntropy > 0 && truncated_entropy(defaults, entropy) < *options.MinEntropy {
+		log.Warnf("Adaptive padding can truncate passwords to %d characters, leaving roughly %.2f bits of entropy, below the minimum of %.2f", shortest_target_length(defaults), truncated_entropy(defaults, entropy), *options.MinEntropy)
+	}
+
+	defaults.WordCandidates = candidate_words(defaults)
+	log.Debugf("len(WordCandidates) = %v", len(defaults.WordCandidates))
+	if defaults.WordSelection == SelectByLength {
+		defaults.WordBuckets, defaults.BucketWeights = word_buckets(defaults, defaults.WordCandidates)
+	}
+	defaults.DigitsBounds = digits_bounds(defaults)
+
+	if *options.Interactive {
+		err = interactive_loop(stdin, stdout, stderr, defaults)
+		if err != nil {
+			logMain.Error("Error generating output: ", err)
+			return ExitImpossible
+		}
+		return ExitOK
+	}
+
+	if *options.BloomFile != "" {
+		bloomFilter, err = read_bloom_filter(*options.BloomFile)
+		if err != nil {
+			logMain.Error("Error reading bloom filter: ", err)
+			return ExitError
+		}
+	}
+
+	// Write to the output file if one was given, otherwise stdout
+	output = stdout
+	if *options.Output != "" {
+		if *options.Append {
+			outputFile, err = open_append(*options.Output, options.Between, options.Terminator)
+		} else {
+			outputFile, err = os.Create(*options.Output)
+		}
+		if err != nil {
+			logMain.Error("Error creating output file: ", err)
+			return ExitError
+		}
+		// Closes it on the early returns; closing it again after the
+		// explicit Close below does nothing
+		defer outputFile.Close()
+		output = outputFile
+	}
+
+	// With a rate each password is written as soon as it is generated, and
+	// an interrupt stops generation between passwords
+	if *options.Rate > 0 {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / *options.Rate))
+		defer ticker.Stop()
+	}
+
+	if *options.Parallel > 1 {
+		start = time.Now()
+		generated, err = generate_passwords_parallel(defaults, options.NumPasswords, *options.Parallel)
+		if err != nil {
+			logMain.Error("Error generating output: ", err)
+			return ExitImpossible
+		}
+		log.WithField("duration", time.Since(start)).Debugf("Generated %d passwords with %d workers", options.NumPasswords, *options.Parallel)
+	} else {
+		generated = make([]Password, 0, options.NumPasswords)
+generate:
+		for i := 0; i < options.NumPasswords; i++ {
+			if ticker != nil && i > 0 {
+				select {
+				case <-ctx.Done():
+					log.Warnf("Interrupted after %d passwords", i)
+					break generate
+				case <-ticker.C:
+				}
+			}
+			var parts Password
+			start = time.Now()
+			// Generate the password based on the data in the defaults structure
+			if bloomFilter != nil {
+				parts, err = generate_unseen_password(defaults, bloomFilter)
+			} else {
+				parts, err = generate_password_parts(defaults)
+			}
+			if err != nil {
+				logMain.Error("Error generating output: ", err)
+				return ExitImpossible
+			}
+			// Includes any regenerations
+			log.WithField("duration", time.Since(start)).Debugf("Generated password %d", i + 1)
+			generated = append(generated, parts)
+			if ticker != nil {
+				err = write_password(output, i, parts.String, *options.IndexPrefix, options.Terminator)
+				if err != nil {
+					logMain.Error("Error writing passwords: ", err)
+					return ExitError
+				}
+			}
+		}
+	}
+
+	if bloomFilter != nil {
+		err = write_bloom_filter(*options.BloomFile, bloomFilter)
+		if err != nil {
+			logMain.Error("Error writing bloom filter: ", err)
+			return ExitError
+		}
+	}
+
+	if is_flag_set(options.Flags, "entropy-floor") {
+		generated = filter_by_entropy_floor(defaults, generated, *options.EntropyFloor)
+		log.Infof("%d of %d passwords meet the entropy floor of %.2f bits", len(generated), options.NumPasswords, *options.EntropyFloor)
+		if len(generated) == 0 {
+			logMain.Error(fmt.Sprintf("Error: No password meets the entropy floor of %.2f bits", *options.EntropyFloor))
+			return ExitImpossible
+		}
+	}
+	passwords = make([]string, len(generated))
+	for i, parts := range generated {
+		passwords[i] = parts.String
+	}
+
+	if is_flag_set(options.Flags, "choose") {
+		password, err = choose_password(stdin, stderr, passwords)
+		if err != nil {
+			logMain.Error("Error choosing password: ", err)
+			return ExitError
+		}
+		passwords = []string{ password }
+	}
+
+	// Kept out of the terminal's scrollback unless asked for
+	if *options.Copy {
+		err = clipboardWriter(passwords[0])
+		if err != nil {
+			logMain.Error("Error copying password: ", err)
+			return ExitError
+		}
+		log.Infof("Copied the password to the clipboard")
+	}
+
+	if *options.Copy && !*options.Print && *options.Output == "" {
+		if *options.ShowEntropy {
+			fmt.Fprintf(stderr, "entropy: %.2f bits\n", entropy)
+		}
+	} else if ticker != nil {
+		// Already written as they were generated
+		if *options.ShowEntropy {
+			fmt.Fprintf(stderr, "entropy: %.2f bits\n", entropy)
+		}
+	} else if *options.JSON {
+		if *options.ShowEntropy {
+			err = write_json(output, passwords, entropy)
+		} else {
+			err = write_json(output, passwords, 0)
+		}
+	} else {
+		// Only the written passwords are quoted, not the copied or hooked ones
+		formatted, err = format_passwords(passwords, *options.OutputFormat)
+		if err == nil {
+			err = write_passwords(output, formatted, *options.IndexPrefix, options.Between, options.Terminator)
+		}
+		if err == nil && *options.ShowEntropy {
+			fmt.Fprintf(stderr, "entropy: %.2f bits\n", entropy)
+		}
+	}
+	if err != nil {
+		logMain.Error("Error writing passwords: ", err)
+		return ExitError
+	}
+	if *options.Estimate {
+		fmt.Fprintf(stderr, "estimated time to guess: %v at %g guesses/sec\n", guess_time(entropy, *options.GuessRate), *options.GuessRate)
+	}
+
+	// Closed explicitly so that an error closing it is reported
+	if outputFile != nil {
+		err = outputFile.Close()
+		if err != nil {
+			logMain.Error("Error closing output file: ", err)
+			return ExitError
+		}
+	}
+
+	if *options.OnGenerate != "" {
+		var failed int = 0
+		for i, password := range passwords {
+			err = run_hook(*options.OnGenerate, password, stderr)
+			if err != nil {
+				log.Errorf("Password %d: %v", i + 1, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			logMain.Error(fmt.Sprintf("Error: on-generate failed for %d of %d passwords", failed, len(passwords)))
+			return ExitError
 		}
 	}
 
-	os.Exit(0)
+	return ExitOK
 }
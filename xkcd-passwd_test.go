@@ -0,0 +1,2399 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Runs the program with the arguments, feeding it stdin, and returns the
+// exit status, stdout and stderr
+func run_capture(stdin string, arguments ...string) (int, string, string) {
+
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+		status int
+	)
+
+	status = run(arguments, strings.NewReader(stdin), &stdout, &stderr)
+
+	return status, stdout.String(), stderr.String()
+}
+
+// The lines written to stdout, without the trailing newline
+func output_lines(stdout string) []string {
+
+	return strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+}
+
+func TestRunGeneratesPasswords(t *testing.T) {
+
+	status, stdout, stderr := run_capture("", "-no-config", "3")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	if lines := output_lines(stdout); len(lines) != 3 {
+		t.Errorf("got %d passwords, want 3: %q", len(lines), stdout)
+	}
+}
+
+func TestRunIsReentrant(t *testing.T) {
+
+	var (
+		seeds []string = []string{ "00", "01", "02", "03" }
+		want map[string]string = map[string]string{}
+		results chan [2]string = make(chan [2]string, 4 * len(seeds))
+	)
+
+	for _, seed := range seeds {
+		_, want[seed], _ = run_capture("", "-no-config", "-seed", seed, "5")
+	}
+
+	// Each run keeps its own random stream, logger and flags
+	for i := 0; i < 4; i++ {
+		for _, seed := range seeds {
+			go func(seed string) {
+				_, stdout, _ := run_capture("", "-no-config", "-debug", "-seed", seed, "5")
+				results <- [2]string{ seed, stdout }
+			}(seed)
+		}
+	}
+	for i := 0; i < 4 * len(seeds); i++ {
+		result := <-results
+		if result[1] != want[result[0]] {
+			t.Errorf("seed %v: %q alongside other runs, want %q", result[0], result[1], want[result[0]])
+		}
+	}
+
+	status, _, stderr := run_capture("", "-no-config", "1")
+	if status != ExitOK || stderr != "" {
+		t.Errorf("after -debug runs: status = %d, stderr = %q", status, stderr)
+	}
+}
+
+func TestOnGenerateWritesToStderr(t *testing.T) {
+
+	status, stdout, stderr := run_capture("", "-no-config", "-on-generate", "sed s/^/hook:/", "2")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, password := range output_lines(stdout) {
+		if !strings.Contains(stderr, "hook:" + password + "\n") {
+			t.Errorf("the hook's output %q is missing %v", stderr, password)
+		}
+	}
+	if strings.Contains(stdout, "hook:") {
+		t.Errorf("the hook wrote to stdout: %q", stdout)
+	}
+}
+
+func TestRunConfigFromStdin(t *testing.T) {
+
+	status, stdout, stderr := run_capture(presets["XKCD"].JSON, "-config", "-")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	// Four words joined by dashes, with no padding
+	if words := strings.Split(strings.TrimSpace(stdout), "-"); len(words) != 4 {
+		t.Errorf("got %q, want four words joined by dashes", stdout)
+	}
+}
+
+func TestRunChooseFromStdin(t *testing.T) {
+
+	status, stdout, stderr := run_capture("2\n", "-no-config", "-choose", "3")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	// The candidates are listed on stderr as "n: password"
+	if !strings.Contains(stderr, "2: " + strings.TrimSpace(stdout) + "\n") {
+		t.Errorf("chose %q, which is not the second candidate in %q", stdout, stderr)
+	}
+}
+
+func TestRunInteractiveFromStdin(t *testing.T) {
+
+	status, stdout, stderr := run_capture("\n\nq\n", "-no-config", "-interactive")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	if lines := output_lines(stdout); len(lines) != 3 {
+		t.Errorf("got %d passwords, want 3: %q", len(lines), stdout)
+	}
+}
+
+func TestKeyboardLayoutSeparatorSequence(t *testing.T) {
+
+	var tests = []struct {
+		sequence		string
+		status			int
+	}{
+		{ "£,§", ExitUsage },
+		{ "£,-", ExitOK },
+		{ "-,.", ExitOK },
+	}
+
+	for _, test := range tests {
+		status, stdout, stderr := run_capture("", "-no-config", "-keyboard-layout", "us", "-separator-per-position", test.sequence, "5")
+		if status != test.status {
+			t.Errorf("%v: status = %d, want %d, stderr = %v", test.sequence, status, test.status, stderr)
+		}
+		if strings.ContainsAny(stdout, "£§") {
+			t.Errorf("%v: got a separator the us layout cannot type: %q", test.sequence, stdout)
+		}
+	}
+}
+
+func TestAvoidAmbiguousAfterOverrides(t *testing.T) {
+
+	var tests = []struct {
+		arguments		[]string
+		status			int
+	}{
+		{ []string{ "-separator-per-position", "l,1" }, ExitImpossible },
+		{ []string{ "-separator-per-position", "l,1,-" }, ExitOK },
+		{ []string{ "-separator-per-position", "l,1,-", "-leet" }, ExitOK },
+		{ []string{ "-leet" }, ExitOK },
+	}
+
+	for _, test := range tests {
+		// Leaving out the ambiguous words means any ambiguous character
+		// came from a separator, digit or leet substitution
+		arguments := append([]string{ "-no-config", "-avoid-ambiguous", "-avoid-ambiguous-words", "-count", "20" }, test.arguments...)
+		status, stdout, stderr := run_capture("", arguments...)
+		if status != test.status {
+			t.Errorf("%v: status = %d, want %d, stderr = %v", test.arguments, status, test.status, stderr)
+		}
+		if strings.ContainsAny(stdout, defaultAmbiguousCharacters) {
+			t.Errorf("%v: got an ambiguous character: %q", test.arguments, stdout)
+		}
+	}
+}
+
+func TestDrawEntropy(t *testing.T) {
+
+	var tests = []struct {
+		words			[]string
+		entropy			float64
+	}{
+		{ []string{ "able", "bake", "cart", "dome" }, 2 },
+		{ []string{ "able", "bake", "cart", "dome", "able", "bake", "cart", "dome" }, 2 },
+		// able is drawn half of the time
+		{ []string{ "able", "able", "bake", "cart" }, 1.5 },
+		{ []string{ "able", "able" }, 0 },
+	}
+
+	for _, test := range tests {
+		if entropy := draw_entropy(test.words); math.Abs(entropy - test.entropy) > 1e-9 {
+			t.Errorf("draw_entropy(%v) = %v, want %v", test.words, entropy, test.entropy)
+		}
+	}
+}
+
+func TestConcatDuplicatesAddNoEntropy(t *testing.T) {
+
+	var (
+		list string = filepath.Join(t.TempDir(), "words.txt")
+		entropies []string
+	)
+
+	if err := os.WriteFile(list, []byte("able\nbake\ncart\ndome\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, strategy := range []string{ "union", "concat" } {
+		status, stdout, stderr := run_capture("", "entropy", "-no-config", "-min-length", "4", "-max-length", "4", "-dictionary", list, "-dictionary", list, "-merge-strategy", strategy)
+		if status != ExitOK {
+			t.Fatalf("%v: status = %d, stderr = %v", strategy, status, stderr)
+		}
+		entropies = append(entropies, stdout)
+	}
+	if entropies[0] != entropies[1] {
+		t.Errorf("union gives %q bits but concat of the same list twice gives %q", entropies[0], entropies[1])
+	}
+}
+
+func TestPrioritiseDictionaries(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		preferred []string = []string{ "able", "bake", "cart" }
+		fallback []string = []string{ "zebra", "yacht", "xylem" }
+		list []string
+		used int
+	)
+
+	defaults.WordLengthMin = 4
+	defaults.WordLengthMax = 5
+	list, used = prioritise_dictionaries(defaults, [][]string{ preferred, fallback })
+	if used != 0 || strings.Join(list, " ") != strings.Join(preferred, " ") {
+		t.Errorf("bounds 4-5: used %d (%v), want the preferred list", used, list)
+	}
+
+	defaults.WordLengthMin = 5
+	list, used = prioritise_dictionaries(defaults, [][]string{ preferred, fallback })
+	if used != 1 || strings.Join(list, " ") != strings.Join(fallback, " ") {
+		t.Errorf("bounds 5-5: used %d (%v), want the fallback list", used, list)
+	}
+
+	defaults.WordLengthMin = 9
+	defaults.WordLengthMax = 9
+	if list, used = prioritise_dictionaries(defaults, [][]string{ preferred, fallback }); used != -1 || list != nil {
+		t.Errorf("bounds 9-9: used %d (%v), want none", used, list)
+	}
+}
+
+func TestWordsSourcePriority(t *testing.T) {
+
+	var (
+		dir string = t.TempDir()
+		preferred string = filepath.Join(dir, "preferred.txt")
+		fallback string = filepath.Join(dir, "fallback.txt")
+	)
+
+	if err := os.WriteFile(preferred, []byte("able\nbake\ncart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fallback, []byte("zebra\nyacht\nxylem\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		min		string
+		words		string
+	}{
+		{ "4", "able bake cart" },
+		{ "5", "zebra yacht xylem" },
+	} {
+		status, stdout, stderr := run_capture("", "-no-config", "-words-source-priority", "-dictionary", preferred, "-dictionary", fallback, "-min-length", test.min, "-max-length", "5", "-case", "lower", "-format", "{{range .WordList}}{{.}} {{end}}", "-words", "3", "5")
+		if status != ExitOK {
+			t.Fatalf("min %v: status = %d, stderr = %v", test.min, status, stderr)
+		}
+		for _, line := range output_lines(stdout) {
+			for _, word := range strings.Fields(line) {
+				if !strings.Contains(" "+test.words+" ", " "+word+" ") {
+					t.Errorf("min %v: %q uses %q, not one of %v", test.min, line, word, test.words)
+				}
+			}
+		}
+	}
+
+	status, _, _ := run_capture("", "-no-config", "-words-source-priority", "-dictionary", preferred, "-dictionary", fallback, "-min-length", "8", "-max-length", "9")
+	if status != ExitDictionary {
+		t.Errorf("no words within the bounds: status = %d, want %d", status, ExitDictionary)
+	}
+	status, _, _ = run_capture("", "-no-config", "-words-source-priority", "-merge-strategy", "concat", "-dictionary", preferred)
+	if status != ExitUsage {
+		t.Errorf("with merge-strategy: status = %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestRenderSpaces(t *testing.T) {
+
+	var (
+		assignment *regexp.Regexp = regexp.MustCompile(`^XKCD_PASSWORD_[0-9]+='([^']*)'$`)
+	)
+
+	// Four words and three spaces between them, padded to a length which
+	// counts the spaces
+	status, stdout, stderr := run_capture("", "-no-config", "-render-spaces", "-words", "4", "-fuzzy-length", "40-40", "5")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		if len(line) != 40 {
+			t.Errorf("%q is %d characters long, want 40", line, len(line))
+		}
+		if strings.Count(line, " ") != 3 + 2 {
+			t.Errorf("%q does not have a space between each word and digit group", line)
+		}
+	}
+
+	status, stdout, stderr = run_capture("", "-no-config", "-render-spaces", "-output-format", "env", "3")
+	if status != ExitOK {
+		t.Fatalf("env: status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		match := assignment.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("env: %q is not a quoted assignment", line)
+		} else if !strings.Contains(match[1], " ") {
+			t.Errorf("env: %q has no spaces", line)
+		}
+	}
+
+	status, stdout, _ = run_capture("", "-no-config", "-render-spaces", "-output-format", "env", "1")
+	if status != ExitOK || !strings.HasPrefix(stdout, "XKCD_PASSWORD='") {
+		t.Errorf("env with one password: status = %d, output %q", status, stdout)
+	}
+	status, _, _ = run_capture("", "-no-config", "-output-format", "env", "-json", "1")
+	if status != ExitUsage {
+		t.Errorf("env with json: status = %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestFormatPasswords(t *testing.T) {
+
+	var tests = []struct {
+		format			string
+		passwords		[]string
+		want			[]string
+	}{
+		{ "plain",	[]string{ "a b" },		[]string{ "a b" } },
+		{ "shell",	[]string{ "a b", "it's" },	[]string{ "'a b'", `'it'\''s'` } },
+		{ "env",	[]string{ "a $b" },		[]string{ "XKCD_PASSWORD='a $b'" } },
+		{ "env",	[]string{ "a", "b" },		[]string{ "XKCD_PASSWORD_1='a'", "XKCD_PASSWORD_2='b'" } },
+	}
+
+	for _, test := range tests {
+		got, err := format_passwords(test.passwords, test.format)
+		if err != nil {
+			t.Errorf("%v: %v", test.format, err)
+			continue
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%v: %q, want %q", test.format, got, test.want)
+		}
+	}
+	if _, err := format_passwords([]string{ "a" }, "yaml"); err == nil {
+		t.Errorf("yaml: no error")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+
+	var tests = []struct {
+		format			string
+		numWords		int
+		valid			bool
+	}{
+		{ "{{index .WordList 1}}+{{index .WordList 0}}", 2, true },
+		{ "{{index .WordList 1}}+{{index .WordList 0}}", 4, true },
+		{ "{{index .WordList 2}}", 2, false },
+		{ "{{.Words}}{{digits 2}}", 3, true },
+		{ "{{range $i, $w := .WordList}}{{if $i}}{{digits 2}}{{end}}{{$w}}{{end}}", 3, true },
+		{ "{{digits -1}}", 3, false },
+		{ "{{.Bogus}}", 3, false },
+		{ "{{.Words", 3, false },
+	}
+
+	for _, test := range tests {
+		_, err := parse_format(test.format, Defaults{ NumWords: test.numWords })
+		if (err == nil) != test.valid {
+			t.Errorf("parse_format(%q) with %d words: err = %v, want valid %v", test.format, test.numWords, err, test.valid)
+		}
+	}
+}
+
+func TestFormatOutput(t *testing.T) {
+
+	var tests = []struct {
+		format			string
+		want			string
+	}{
+		{ "{{index .WordList 1}}+{{index .WordList 0}}", "^[A-Za-z]+\\+[A-Za-z]+$" },
+		{ "{{digits 4}}", "^[0-9]{4}$" },
+		{ "{{index .WordList 0}}{{digits 2}}", "^[A-Za-z]+[0-9]{2}$" },
+	}
+
+	for _, test := range tests {
+		status, stdout, stderr := run_capture("", "-no-config", "-render-only-letters", "-words", "2", "-format", test.format, "5")
+		if status != ExitOK {
+			t.Fatalf("%q: status = %d, stderr = %v", test.format, status, stderr)
+		}
+		for _, line := range output_lines(stdout) {
+			if !regexp.MustCompile(test.want).MatchString(line) {
+				t.Errorf("%q: got %q, want %v", test.format, line, test.want)
+			}
+		}
+	}
+}
+
+func TestMaximumWordsLength(t *testing.T) {
+
+	var (
+		words []string = []string{ "able", "bakers", "carting" }
+		tests = []struct {
+			name			string
+			defaults		Defaults
+			length			int
+		}{
+			// The longest word is 7 characters, below word_length_max
+			{ "longest word", Defaults{ NumWords: 3, WordLengthMin: 4, WordLengthMax: 8, SeparatorCharacter: SeparatorRandom }, 3 * 7 + 2 },
+			{ "no separator", Defaults{ NumWords: 3, WordLengthMin: 4, WordLengthMax: 8, SeparatorCharacter: SeparatorNone }, 3 * 7 },
+			{ "digits before", Defaults{ NumWords: 3, WordLengthMin: 4, WordLengthMax: 8, SeparatorCharacter: SeparatorRandom, PaddingDigitsBefore: 2, PaddingDigitsBeforeMax: 4 }, 4 + 1 + 3 * 7 + 2 },
+			{ "digits after", Defaults{ NumWords: 3, WordLengthMin: 4, WordLengthMax: 8, SeparatorCharacter: SeparatorRandom, PaddingDigitsAfter: 4 }, 3 * 7 + 2 },
+			{ "between-all", Defaults{ NumWords: 3, WordLengthMin: 4, WordLengthMax: 8, SeparatorCharacter: SeparatorRandom, PaddingDigitsAfter: 2, DigitPlacement: DigitsBetweenAll }, 3 * 7 + 2 + 2 * (2 + 1) },
+			{ "random-gap", Defaults{ NumWords: 3, WordLengthMin: 4, WordLengthMax: 8, SeparatorCharacter: SeparatorRandom, PaddingDigitsAfter: 2, PaddingDigitsAfterMax: 3, DigitPlacement: DigitsRandomGap }, 3 * 7 + 2 + 3 + 1 },
+			{ "syllables", Defaults{ NumWords: 2, Mode: WordsSyllable, SyllablesPerWord: 3, SeparatorCharacter: SeparatorRandom }, 2 * 6 + 1 },
+		}
+	)
+
+	for _, test := range tests {
+		test.defaults.WordDictionary = words
+		if length := maximum_words_length(test.defaults); length != test.length {
+			t.Errorf("%v: maximum_words_length = %d, want %d", test.name, length, test.length)
+		}
+	}
+}
+
+func TestAdaptivePaddingTruncatesOnlyPadding(t *testing.T) {
+
+	// Four to eight letter words need up to 8 + 1 + 8 characters
+	status, _, stderr := run_capture("", "-no-config", "-words", "2", "-min-length", "4", "-max-length", "8", "-digits-before", "0", "-fuzzy-length", "14-20")
+	if status != ExitConfig {
+		t.Errorf("status = %d, want %d, stderr = %v", status, ExitConfig, stderr)
+	}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-words", "2", "-min-length", "4", "-max-length", "8", "-digits-before", "0", "-separator", "-", "-fuzzy-length", "17-20", "50")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		if words := strings.Split(line, "-"); len(words) < 2 || len(words[1]) < 4 {
+			t.Errorf("%q lost part of its last word", line)
+		}
+	}
+}
+
+func TestRunsOn(t *testing.T) {
+
+	var (
+		runOn = map[CaseType]bool{
+			CaseNone:		true,
+			CaseAlternate:		true,
+			CaseCapitalise:		false,
+			CaseInvert:		false,
+			CaseUpper:		true,
+			CaseRandom:		true,
+			CaseFirstLetter:	false,
+			CaseWordRandom:		true,
+			CaseTitle:		true,
+			CaseSyllable:		false,
+		}
+	)
+
+	for caseType, want := range runOn {
+		defaults := Defaults{ NumWords: 3, CaseTransform: caseType, SeparatorCharacter: SeparatorNone }
+		if got := runs_on(defaults); got != want {
+			t.Errorf("%v without a separator: runs_on = %v, want %v", case_type_name(caseType), got, want)
+		}
+		// Anything between the words keeps them apart whatever the case
+		defaults.SeparatorCharacter = SeparatorRandom
+		if runs_on(defaults) {
+			t.Errorf("%v with a separator: runs_on = true", case_type_name(caseType))
+		}
+	}
+
+	for _, defaults := range []Defaults{
+		{ NumWords: 3, SeparatorSequence: []string{ "-" } },
+		{ NumWords: 3, DigitPlacement: DigitsBetweenAll },
+		{ NumWords: 1 },
+	} {
+		if runs_on(defaults) {
+			t.Errorf("runs_on(%+v) = true", defaults)
+		}
+	}
+}
+
+func TestRunOnWarning(t *testing.T) {
+
+	var tests = []struct {
+		arguments		[]string
+		warned			bool
+	}{
+		{ []string{ "-separator", "none", "-case", "lower" }, true },
+		{ []string{ "-separator", "none", "-case", "word-random" }, true },
+		{ []string{ "-separator", "none", "-case", "capitalise" }, false },
+		{ []string{ "-separator", "-", "-case", "lower" }, false },
+	}
+
+	for _, test := range tests {
+		status, _, stderr := run_capture("", append([]string{ "-no-config" }, test.arguments...)...)
+		if status != ExitOK {
+			t.Fatalf("%v: status = %d, stderr = %v", test.arguments, status, stderr)
+		}
+		if warned := strings.Contains(stderr, "run together"); warned != test.warned {
+			t.Errorf("%v: warned = %v, want %v: %v", test.arguments, warned, test.warned, stderr)
+		}
+	}
+}
+
+func TestRunExitStatus(t *testing.T) {
+
+	var (
+		directory string = t.TempDir()
+		empty string = filepath.Join(directory, "empty.txt")
+		tests = []struct {
+			name			string
+			arguments		[]string
+			status			int
+		}{
+			{ "success", []string{ "-no-config" }, ExitOK },
+			{ "help", []string{ "-h" }, ExitOK },
+			{ "unknown flag", []string{ "-no-such-flag" }, ExitUsage },
+			{ "bad flag value", []string{ "-no-config", "-words", "0" }, ExitUsage },
+			{ "two numbers", []string{ "-no-config", "1", "2" }, ExitUsage },
+			{ "missing config", []string{ "-config", filepath.Join(directory, "missing.json") }, ExitConfig },
+			{ "invalid config", []string{ "-config", empty }, ExitConfig },
+			{ "empty dictionary", []string{ "-no-config", "-dictionary", empty }, ExitDictionary },
+			{ "no words left", []string{ "-no-config", "-word-regex", "^$" }, ExitDictionary },
+			{ "impossible min-entropy", []string{ "-no-config", "-min-entropy", "100000" }, ExitImpossible },
+			{ "unwritable output", []string{ "-no-config", "-output", directory }, ExitError },
+		}
+	)
+
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		status, _, stderr := run_capture("", test.arguments...)
+		if status != test.status {
+			t.Errorf("%v: status = %d, want %d, stderr = %v", test.name, status, test.status, stderr)
+		}
+	}
+}
+
+// Always fails, standing in for an entropy source which has gone away
+type failingReader struct{}
+
+func (failingReader) Read(buffer []byte) (int, error) {
+
+	return 0, errors.New("no entropy")
+}
+
+func TestRandomErrorsAreReturned(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.WordDictionary = dictionary
+	defaults.Random = failingReader{}
+
+	if _, err := random_int(defaults.Random, 10); err == nil {
+		t.Errorf("random_int returned no error")
+	}
+	if _, err := random_float(defaults.Random); err == nil {
+		t.Errorf("random_float returned no error")
+	}
+	if _, err := random_digits(defaults, 4); err == nil {
+		t.Errorf("random_digits returned no error")
+	}
+	if _, err := random_word(defaults); err == nil {
+		t.Errorf("random_word returned no error")
+	}
+	if _, err := generate_password(defaults); err == nil {
+		t.Errorf("generate_password returned no error")
+	}
+
+	// Through the same steps as run, with the failing reader in place of
+	// the one the options give
+	var stdout, stderr bytes.Buffer
+	logMain := &logrus.Logger{ Out: &stderr, Formatter: new(logrus.TextFormatter), Level: logrus.DebugLevel }
+	options, done, status := parse_options([]string{ "-no-config" }, &stdout, &stderr, logMain)
+	if done {
+		t.Fatalf("parse_options: status = %d, stderr = %v", status, stderr.String())
+	}
+	defaults, done, status = load_defaults(options, strings.NewReader(""), &stdout, &stderr, logMain)
+	if done {
+		t.Fatalf("load_defaults: status = %d, stderr = %v", status, stderr.String())
+	}
+	defaults.Random = failingReader{}
+	status = generate_output(options, defaults, strings.NewReader(""), &stdout, &stderr, logMain)
+	if status != ExitImpossible || stdout.String() != "" || !strings.Contains(stderr.String(), "no entropy") {
+		t.Errorf("status = %d, stdout = %q, stderr = %v", status, stdout.String(), stderr.String())
+	}
+}
+
+func TestPasswordPartsJoin(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.WordDictionary = dictionary
+	for _, placement := range []DigitPlacement{ DigitsBoth, DigitsBetweenAll } {
+		defaults.DigitPlacement = placement
+		for i := 0; i < 20; i++ {
+			parts, err := generate_password_parts(defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var joined = strings.Repeat(parts.Padding, parts.PaddingBefore)
+			if parts.DigitsBefore != "" {
+				joined += parts.DigitsBefore + parts.Separator
+			}
+			joined += join_words(parts)
+			if parts.DigitsAfter != "" {
+				joined += parts.Separator + parts.DigitsAfter
+			}
+			joined += strings.Repeat(parts.Padding, parts.PaddingAfter)
+			if joined != parts.String {
+				t.Errorf("parts join to %q, want %q", joined, parts.String)
+			}
+		}
+	}
+}
+
+func TestAssembledLengthFitsPassword(t *testing.T) {
+
+	var (
+		defaults Defaults
+		configs = map[string]func(*Defaults){
+			"default":		func(d *Defaults) {},
+			"digits between":	func(d *Defaults) { d.DigitPlacement = DigitsBetweenAll },
+			"random gap":		func(d *Defaults) { d.DigitPlacement = DigitsRandomGap },
+			"leet":			func(d *Defaults) { d.LeetProbability = 1; d.LeetMap = map[rune]string{ 'a': "ä", 'e': "€" } },
+			"syllables":		func(d *Defaults) { d.Mode = WordsSyllable; d.SyllablesPerWord = 4 },
+			"multibyte":		func(d *Defaults) { d.SeparatorAlphabet = []string{ "·", "—" }; d.SymbolAlphabet = []string{ "€" } },
+			"separate padding":	func(d *Defaults) { d.SeparatePaddingDigits = true },
+		}
+	)
+
+	for name, config := range configs {
+		defaults = default_defaults()
+		defaults.WordDictionary = dictionary
+		config(&defaults)
+		for i := 0; i < 20; i++ {
+			parts, err := random_components(defaults)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			result, err := assemble_password(defaults, parts)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			if length := assembled_length(defaults, parts); length != len(result) {
+				t.Errorf("%v: assembled_length = %d, want %d for %q", name, length, len(result), result)
+			}
+		}
+	}
+}
+
+func TestCandidateWordsCoverage(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		want = map[string]bool{}
+		got = map[string]bool{}
+	)
+
+	defaults.WordDictionary = dictionary
+	for _, word := range dictionary {
+		if len(word) >= defaults.WordLengthMin && len(word) <= defaults.WordLengthMax {
+			want[word] = true
+		}
+	}
+	for _, word := range candidate_words(defaults) {
+		if !want[word] {
+			t.Errorf("candidate %q is outside the length bounds", word)
+		}
+		got[word] = true
+	}
+	if len(got) != len(want) {
+		t.Errorf("%d distinct candidates, want %d", len(got), len(want))
+	}
+}
+
+// Picks words with a narrow length bound, so that rejection sampling
+// throws most draws away
+func benchmark_random_word(b *testing.B, candidates bool) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.WordDictionary = dictionary
+	defaults.WordLengthMin = 10
+	defaults.WordLengthMax = 10
+	if candidates {
+		defaults.WordCandidates = candidate_words(defaults)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := random_word(defaults); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRandomWordRejection(b *testing.B) {
+
+	benchmark_random_word(b, false)
+}
+
+func BenchmarkRandomWordCandidates(b *testing.B) {
+
+	benchmark_random_word(b, true)
+}
+
+func TestParallelKeepsOrder(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.WordDictionary = dictionary
+	passwords, err := generate_passwords_parallel(defaults, 50, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passwords) != 50 {
+		t.Fatalf("%d passwords, want 50", len(passwords))
+	}
+	for i, password := range passwords {
+		if password.String == "" {
+			t.Errorf("password %d is empty", i)
+		}
+	}
+
+	_, sequential, _ := run_capture("", "-no-config", "-seed", "0123", "20")
+	status, parallel, stderr := run_capture("", "-no-config", "-seed", "0123", "-parallel", "4", "20")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	if len(output_lines(parallel)) != 20 || parallel != sequential {
+		t.Errorf("parallel output %q, want %q", parallel, sequential)
+	}
+}
+
+func benchmark_generate_passwords(b *testing.B, workers int) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.WordDictionary = dictionary
+	defaults.WordCandidates = candidate_words(defaults)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := generate_passwords_parallel(defaults, 100, workers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGeneratePasswordsOneWorker(b *testing.B) {
+
+	benchmark_generate_passwords(b, 1)
+}
+
+func BenchmarkGeneratePasswordsFourWorkers(b *testing.B) {
+
+	benchmark_generate_passwords(b, 4)
+}
+
+func TestValidateRanges(t *testing.T) {
+
+	var tests = []struct {
+		name		string
+		change		func(*Defaults)
+		want		string
+	}{
+		{ "valid",			func(d *Defaults) {},						"" },
+		{ "no words",			func(d *Defaults) { d.NumWords = 0 },				"num_words must be at least 1" },
+		{ "negative words",		func(d *Defaults) { d.NumWords = -3 },				"num_words must be at least 1" },
+		{ "zero minimum length",	func(d *Defaults) { d.WordLengthMin = 0 },			"word_length_min must be at least 1" },
+		{ "minimum over maximum",	func(d *Defaults) { d.WordLengthMin = 9 },			"word_length_min (9) is greater than word_length_max (8)" },
+		{ "negative digits before",	func(d *Defaults) { d.PaddingDigitsBefore = -1 },		"padding_digits_before must not be negative" },
+		{ "negative digits after",	func(d *Defaults) { d.PaddingDigitsAfter = -1 },		"padding_digits_after must not be negative" },
+		{ "digits before maximum",	func(d *Defaults) { d.PaddingDigitsBeforeMax = 2 },		"padding_digits_before_max (2) is less than" },
+		{ "digits after maximum",	func(d *Defaults) { d.PaddingDigitsAfterMax = 2 },		"padding_digits_after_max (2) is less than" },
+		{ "negative padding before",	func(d *Defaults) { d.PaddingCharactersBefore = -1 },		"padding_characters_before must not be negative" },
+		{ "negative padding after",	func(d *Defaults) { d.PaddingCharactersAfter = -1 },		"padding_characters_after must not be negative" },
+		{ "padding before maximum",	func(d *Defaults) { d.PaddingCharsBeforeMax = 1 },		"padding_characters_before_max (1) is less than" },
+		{ "padding after maximum",	func(d *Defaults) { d.PaddingCharsAfterMax = 1 },		"padding_characters_after_max (1) is less than" },
+		{ "total lengths reversed",	func(d *Defaults) { d.MinTotalLength = 30; d.MaxTotalLength = 20 },	"min_total_length (30) is greater than" },
+		{ "adaptive without length",	func(d *Defaults) { d.PaddingType = PaddingAdaptive },		"pad_to_length must be at least 1" },
+		{ "multiple without size",	func(d *Defaults) { d.PaddingType = PaddingMultiple },		"pad_to_multiple must be at least 1" },
+		{ "negative identical",		func(d *Defaults) { d.MaxIdenticalAdjacent = -1 },		"max_identical_adjacent must not be negative" },
+		{ "too many syllables",		func(d *Defaults) { d.Mode = WordsSyllable; d.SyllablesPerWord = 9 },	"syllables_per_word must be between 1 and 8" },
+		{ "between with one word",	func(d *Defaults) { d.NumWords = 1; d.DigitPlacement = DigitsBetweenAll },	"needs at least two words" },
+		{ "uppercase ratio",		func(d *Defaults) { d.UppercaseRatio = 1.5 },			"uppercase_ratio must be between 0 and 1" },
+		{ "leet probability",		func(d *Defaults) { d.LeetProbability = -0.1 },			"leet_probability must be between 0 and 1" },
+		{ "inject probability",		func(d *Defaults) { d.InjectSymbolProbability = 0.6 },		"inject_symbol_probability must be between 0 and 0.5" },
+		{ "long separator",		func(d *Defaults) { d.SeparatorAlphabet = []string{ "--" } },	"separator_alphabet entries must be a single character" },
+		{ "empty digit alphabet",	func(d *Defaults) { d.DigitAlphabet = []string{} },		"digit_alphabet must not be empty" },
+		{ "separator weights count",	func(d *Defaults) { d.SeparatorWeights = []float64{ 1 } },	"separator_weights has 1 entries" },
+		{ "zero length weight",		func(d *Defaults) { d.LengthWeights = map[int]float64{ 0: 1 } },	"length_weights lengths must be at least 1" },
+	}
+
+	for _, test := range tests {
+		var defaults Defaults = default_defaults()
+		test.change(&defaults)
+		err := validate_ranges(defaults)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%v: validate_ranges = %v, want no error", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: validate_ranges = %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestValidateRangesReportsEveryProblem(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.NumWords = 0
+	defaults.PaddingDigitsAfter = -1
+	defaults.UppercaseRatio = 2
+	err := validate_ranges(defaults)
+	if err == nil || len(strings.Split(err.Error(), "\n")) != 3 {
+		t.Errorf("validate_ranges = %v, want three errors", err)
+	}
+}
+
+// Returns a seeded random reader, so that the statistical tests give the
+// same draws every run
+func seeded_random(t *testing.T, seed string) io.Reader {
+
+	reader, err := new_seeded_reader(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reader
+}
+
+func TestSeparatorWeightsDistribution(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		counts = map[string]int{}
+		draws int = 20000
+	)
+
+	defaults.Random = seeded_random(t, "5eed")
+	defaults.SeparatorAlphabet = []string{ "-", ".", "_" }
+	defaults.SeparatorWeights = []float64{ 7, 3, 0 }
+	for i := 0; i < draws; i++ {
+		separator, err := random_separator(defaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[separator]++
+	}
+
+	for i, separator := range defaults.SeparatorAlphabet {
+		var want = defaults.SeparatorWeights[i] / 10
+		if got := float64(counts[separator]) / float64(draws); math.Abs(got - want) > 0.02 {
+			t.Errorf("%q was picked %.3f of the time, want %.3f", separator, got, want)
+		}
+	}
+	if counts["_"] != 0 {
+		t.Errorf("a separator with no weight was picked %d times", counts["_"])
+	}
+}
+
+func TestUppercaseRatioDistribution(t *testing.T) {
+
+	var (
+		word string = strings.Repeat("abcdefghij", 2000)
+		random io.Reader = seeded_random(t, "cafe")
+	)
+
+	for _, ratio := range []float64{ 0, 0.25, 0.5, 0.9, 1 } {
+		result, err := random_case(random, word, ratio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The word is all lowercase, so each changed letter was uppercased
+		var upper int
+		for i := range word {
+			if result[i] != word[i] {
+				upper++
+			}
+		}
+		if got := float64(upper) / float64(len(word)); math.Abs(got - ratio) > 0.02 {
+			t.Errorf("ratio %v made %.3f of the letters uppercase", ratio, got)
+		}
+	}
+}
+
+func TestLengthWeightsDistribution(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		candidates []string
+		draws int = 20000
+	)
+
+	// Returns the fraction of draws of each length
+	var sample = func(defaults Defaults) map[int]float64 {
+		var fractions = map[int]float64{}
+		for i := 0; i < draws; i++ {
+			word, err := random_word(defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fractions[len(word)] += 1 / float64(draws)
+		}
+		return fractions
+	}
+
+	defaults.Random = seeded_random(t, "1e9e")
+	defaults.WordDictionary = dictionary
+	candidates = candidate_words(defaults)
+	defaults.WordCandidates = candidates
+
+	// Without it each length is as likely as its share of the candidates
+	var counts = map[int]int{}
+	for _, word := range candidates {
+		counts[len(word)]++
+	}
+	for length, got := range sample(defaults) {
+		if want := float64(counts[length]) / float64(len(candidates)); math.Abs(got - want) > 0.02 {
+			t.Errorf("uniform: length %d was picked %.3f of the time, want %.3f", length, got, want)
+		}
+	}
+
+	// With it each length is as likely as its weight, 1 when unset
+	defaults.LengthWeights = map[int]float64{ 4: 4, 8: 0 }
+	defaults.WordBuckets, defaults.BucketWeights = word_buckets(defaults, candidates)
+	var wants = map[int]float64{ 4: 4.0 / 7, 5: 1.0 / 7, 6: 1.0 / 7, 7: 1.0 / 7, 8: 0 }
+	var fractions = sample(defaults)
+	for length, want := range wants {
+		if got := fractions[length]; math.Abs(got - want) > 0.02 {
+			t.Errorf("weighted: length %d was picked %.3f of the time, want %.3f", length, got, want)
+		}
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+
+	var tests = []struct {
+		spec		string
+		want		map[string]string
+	}{
+		{ "4w-cap-2d",		map[string]string{ "words": "4", "case": "capitalise", "digits-after": "2" } },
+		{ "3b-wrand",		map[string]string{ "digits-before": "3", "case": "word-random" } },
+		{ "upper",		map[string]string{ "case": "upper" } },
+		{ "word-random",	nil },
+		{ "4w-5w",		nil },
+	}
+
+	for _, test := range tests {
+		overrides, err := parse_spec(test.spec)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("parse_spec(%q) = %v, want an error", test.spec, overrides)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse_spec(%q) = %v", test.spec, err)
+			continue
+		}
+		if len(overrides) != len(test.want) {
+			t.Errorf("parse_spec(%q) = %v, want %v", test.spec, overrides, test.want)
+		}
+		for name, value := range test.want {
+			if overrides[name] != value {
+				t.Errorf("parse_spec(%q) gives %v %q, want %q", test.spec, name, overrides[name], value)
+			}
+		}
+	}
+}
+
+func TestOutputAndAppendModes(t *testing.T) {
+
+	var (
+		directory string = t.TempDir()
+		replaced string = filepath.Join(directory, "replaced")
+		appended string = filepath.Join(directory, "appended")
+	)
+
+	if status, _, stderr := run_capture("", "-no-config", "-output", replaced, "2"); status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for i := 0; i < 2; i++ {
+		if status, _, stderr := run_capture("", "-no-config", "-output", appended, "-append", "2"); status != ExitOK {
+			t.Fatalf("status = %d, stderr = %v", status, stderr)
+		}
+	}
+
+	replacedInfo, err := os.Stat(replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendedInfo, err := os.Stat(appended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replacedInfo.Mode() != appendedInfo.Mode() {
+		t.Errorf("-append created mode %v, want %v as without it", appendedInfo.Mode(), replacedInfo.Mode())
+	}
+
+	content, err := os.ReadFile(appended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := output_lines(string(content)); len(lines) != 4 {
+		t.Errorf("appending twice gave %d passwords, want 4", len(lines))
+	}
+}
+
+func TestDryRunOmitsUnsetRules(t *testing.T) {
+
+	status, stdout, stderr := run_capture("", "config", "print", "-no-config")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, field := range []string{ "max_identical_adjacent", "separate_padding_digits", "min_total_length", "max_total_length" } {
+		if strings.Contains(stdout, field) {
+			t.Errorf("%v was printed although it is unset: %v", field, stdout)
+		}
+	}
+}
+
+func TestRandomDigitsNegativeCount(t *testing.T) {
+
+	for _, alphabet := range [][]string{ nil, { "1", "2" } } {
+		if digits, err := random_digits(Defaults{ DigitAlphabet: alphabet }, -1); err == nil {
+			t.Errorf("random_digits(-1) with the alphabet %v = %q, want an error", alphabet, digits)
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+
+	status, stdout, stderr := run_capture("", "-list-presets")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	if lines := output_lines(stdout); len(lines) != len(presets) {
+		t.Errorf("-list-presets listed %d presets, want %d: %q", len(lines), len(presets), stdout)
+	}
+
+	for name := range presets {
+		status, _, stderr := run_capture("", "-preset", strings.ToLower(name), "3")
+		if status != ExitOK {
+			t.Errorf("-preset %v: status = %d, stderr = %v", name, status, stderr)
+		}
+	}
+
+	// Four lowercase words joined by dashes, as in the comic
+	status, stdout, stderr = run_capture("", "-preset", "xkcd", "20")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		if !regexp.MustCompile(`^[a-z]+(-[a-z]+){3}$`).MatchString(line) {
+			t.Errorf("-preset xkcd gave %q", line)
+		}
+	}
+
+	if status, _, _ := run_capture("", "-preset", "nosuch"); status != ExitUsage {
+		t.Errorf("an unknown preset gave status %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestFindDefaultsFile(t *testing.T) {
+
+	var (
+		home string = t.TempDir()
+		config string = t.TempDir()
+		saved = userConfigDir
+	)
+
+	t.Setenv("HOME", home)
+	t.Setenv("XKCD_DEFAULTS", "")
+	userConfigDir = func() (string, error) {
+		return config, nil
+	}
+	defer func() {
+		userConfigDir = saved
+	}()
+
+	// Nothing anywhere is an error naming the places searched
+	_, err := find_defaults_file(discard_logger())
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(home, ".xkcd-defaults.json")) {
+		t.Errorf("find_defaults_file = %v, want an error listing the home directory", err)
+	}
+	status, _, stderr := run_capture("")
+	if status != ExitConfig || !strings.Contains(stderr, "No defaults file found") {
+		t.Errorf("status = %d, stderr = %v", status, stderr)
+	}
+
+	// The configuration directory is used, until there is one in home
+	var inConfig = filepath.Join(config, "xkcd-passwd", "defaults.json")
+	if err = os.MkdirAll(filepath.Dir(inConfig), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(inConfig, []byte(presets["XKCD"].JSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if filename, err := find_defaults_file(discard_logger()); err != nil || filename != inConfig {
+		t.Errorf("find_defaults_file = %v, %v, want %v", filename, err, inConfig)
+	}
+	var inHome = filepath.Join(home, ".xkcd-defaults.json")
+	if err = os.WriteFile(inHome, []byte(presets["XKCD"].JSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if filename, err := find_defaults_file(discard_logger()); err != nil || filename != inHome {
+		t.Errorf("find_defaults_file = %v, %v, want %v", filename, err, inHome)
+	}
+}
+
+func TestWarningsArePlain(t *testing.T) {
+
+	status, _, stderr := run_capture("", "-no-config", "-words", "2", "-separator", "none", "-case", "lower")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	if !strings.HasPrefix(stderr, "warning: Nothing separates the words") || strings.Contains(stderr, "time=") {
+		t.Errorf("stderr = %q, want a plain warning", stderr)
+	}
+}
+
+func TestPasswordEntropy(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.WordDictionary = dictionary
+	defaults.CaseTransform = CaseCapitalise
+
+	// With fixed parts every password has the configuration's entropy
+	parts, err := generate_password_parts(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := password_entropy(defaults, parts), calculate_entropy(defaults); math.Abs(got - want) > 1e-9 {
+		t.Errorf("password_entropy = %v, want calculate_entropy %v", got, want)
+	}
+
+	// Each digit more adds a digit's worth
+	defaults.PaddingDigitsAfter = 1
+	defaults.PaddingDigitsAfterMax = 5
+	var short, long = parts, parts
+	short.DigitsAfter = "1"
+	long.DigitsAfter = "12345"
+	if got := password_entropy(defaults, long) - password_entropy(defaults, short); math.Abs(got - 4 * math.Log2(10)) > 1e-9 {
+		t.Errorf("four more digits added %v bits, want %v", got, 4 * math.Log2(10))
+	}
+}
+
+func TestFilterByEntropyFloor(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		passwords []Password
+	)
+
+	defaults.WordDictionary = dictionary
+	defaults.PaddingDigitsAfter = 1
+	defaults.PaddingDigitsAfterMax = 5
+	for _, digits := range []string{ "12", "12345", "1", "123" } {
+		passwords = append(passwords, Password{ Words: []string{ "a", "b", "c" }, DigitsAfter: digits, String: digits })
+	}
+
+	var base = password_entropy(defaults, passwords[2])
+	var filtered = filter_by_entropy_floor(defaults, passwords, base + 1.5 * math.Log2(10))
+	var got []string
+	for _, password := range filtered {
+		got = append(got, password.String)
+	}
+	if strings.Join(got, ",") != "12345,123" {
+		t.Errorf("filter_by_entropy_floor kept %v, want 12345,123", got)
+	}
+
+	// The floor compares against the same entropy -show-entropy reports
+	status, stdout, stderr := run_capture("", "-no-config", "-show-entropy", "-entropy-floor", "1", "3")
+	if status != ExitOK || len(output_lines(stdout)) != 3 {
+		t.Errorf("status = %d, stdout = %q, stderr = %v", status, stdout, stderr)
+	}
+	var shown float64
+	fmt.Sscanf(stderr, "entropy: %f bits", &shown)
+	status, _, _ = run_capture("", "-no-config", "-entropy-floor", fmt.Sprintf("%.2f", shown + 1), "3")
+	if status != ExitImpossible {
+		t.Errorf("a floor above the entropy gave status %d, want %d", status, ExitImpossible)
+	}
+}
+
+func TestDigitsBounds(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.PaddingDigitsAfter = 2
+	defaults.PaddingDigitsAfterMax = 20
+	var bounds = digits_bounds(defaults)
+	for _, count := range []int{ 4, 2, 11, 20 } {
+		if bounds[count] == nil || bounds[count].Cmp(digits_bound(count)) != 0 {
+			t.Errorf("bounds[%d] = %v, want %v", count, bounds[count], digits_bound(count))
+		}
+	}
+	if _, found := bounds[21]; found {
+		t.Errorf("bounds has 21 digits, more than can be drawn")
+	}
+
+	// The same random stream gives the same digits with or without them
+	var draw = func(bounds map[int]*big.Int) string {
+		var draws []string
+		defaults.DigitsBounds = bounds
+		defaults.Random = seeded_random(t, "d161")
+		for count := 0; count <= 20; count++ {
+			digits, err := random_digits(defaults, count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			draws = append(draws, digits)
+		}
+		return strings.Join(draws, ",")
+	}
+	if with, without := draw(bounds), draw(nil); with != without {
+		t.Errorf("the bounds changed the digits drawn: %v and %v", with, without)
+	}
+}
+
+func benchmark_random_digits(b *testing.B, bounds map[int]*big.Int) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.DigitsBounds = bounds
+	for i := 0; i < b.N; i++ {
+		if _, err := random_digits(defaults, 12); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRandomDigitsComputedBound(b *testing.B) {
+
+	benchmark_random_digits(b, nil)
+}
+
+func BenchmarkRandomDigitsCachedBound(b *testing.B) {
+
+	benchmark_random_digits(b, map[int]*big.Int{ 12: digits_bound(12) })
+}
+
+// Writes the defaults to a defaults file in a temporary directory, for
+// -config, and returns its name
+func write_config(t *testing.T, defaults Defaults) string {
+
+	var filename string = filepath.Join(t.TempDir(), "xkcd-defaults.json")
+
+	jsonData, err := json.Marshal(to_json_defaults(defaults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filename, jsonData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestWordsOverridesConfig(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	defaults.NumWords = 5
+	config := write_config(t, defaults)
+
+	status, stdout, stderr := run_capture("", "-config", config, "-format", "{{len .WordList}}", "2")
+	if status != ExitOK || stdout != "5\n5\n" {
+		t.Errorf("without -words: status = %d, output %q, want two passwords of 5 words, stderr = %v", status, stdout, stderr)
+	}
+	status, stdout, stderr = run_capture("", "-config", config, "-words", "3", "-format", "{{len .WordList}}", "2")
+	if status != ExitOK || stdout != "3\n3\n" {
+		t.Errorf("-words 3: status = %d, output %q, want two passwords of 3 words, stderr = %v", status, stdout, stderr)
+	}
+	for _, words := range []string{ "0", "-1" } {
+		if status, _, _ = run_capture("", "-config", config, "-words", words); status != ExitUsage {
+			t.Errorf("-words %v: status = %d, want %d", words, status, ExitUsage)
+		}
+	}
+}
+
+func TestIndexPrefix(t *testing.T) {
+
+	var buffer bytes.Buffer
+
+	if err := write_passwords(&buffer, []string{ "a-b", "c-d", "e-f" }, true, "\n", "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "1: a-b\n2: c-d\n3: e-f\n" {
+		t.Errorf("write_passwords = %q", buffer.String())
+	}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-index-prefix", "12")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	lines := output_lines(stdout)
+	if len(lines) != 12 {
+		t.Fatalf("%d passwords, want 12", len(lines))
+	}
+	for i, line := range lines {
+		prefix := fmt.Sprintf("%d: ", i + 1)
+		if !strings.HasPrefix(line, prefix) || len(line) == len(prefix) {
+			t.Errorf("line %d is %q, want a password after %q", i + 1, line, prefix)
+		}
+	}
+}
+
+func TestOverridesTakePrecedence(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		resolved map[string]interface{}
+	)
+
+	defaults.WordLengthMin = 5
+	defaults.WordLengthMax = 6
+	defaults.PaddingDigitsBefore = 1
+	defaults.PaddingDigitsAfter = 1
+	defaults.CaseTransform = CaseUpper
+	config := write_config(t, defaults)
+
+	status, stdout, stderr := run_capture("", "config", "print", "-config", config, "-min-length", "4", "-max-length", "9", "-digits-before", "0", "-digits-after", "3", "-case", "title", "-separator", "_")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	if err := json.Unmarshal([]byte(stdout), &resolved); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{
+		"word_length_min":		4.0,
+		"word_length_max":		9.0,
+		"padding_digits_before":	0.0,
+		"padding_digits_after":		3.0,
+		"case_transform":		"title",
+		"separator_character":		"_",
+	} {
+		if resolved[key] != want {
+			t.Errorf("%v = %v, want %v from the flag over the file", key, resolved[key], want)
+		}
+	}
+
+	for _, arguments := range [][]string{
+		{ "-min-length", "0" },
+		{ "-max-length", "-2" },
+		{ "-digits-before", "-1" },
+		{ "-digits-after", "-1" },
+		{ "-case", "sideways" },
+		{ "-separator", "bogus" },
+		{ "-min-length", "four" },
+	} {
+		status, _, stderr = run_capture("", append([]string{ "-config", config }, arguments...)...)
+		if status != ExitUsage {
+			t.Errorf("%v: status = %d, want %d, stderr = %v", arguments, status, ExitUsage, stderr)
+		}
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+
+	for _, test := range []struct {
+		count		string
+		entropy		bool
+	}{
+		{ "1", false },
+		{ "4", false },
+		{ "3", true },
+	} {
+		var decoded []JSON_Password
+
+		arguments := []string{ "-no-config", "-json", test.count }
+		if test.entropy {
+			arguments = append([]string{ "-show-entropy" }, arguments...)
+		}
+		status, stdout, stderr := run_capture("", arguments...)
+		if status != ExitOK {
+			t.Fatalf("%v: status = %d, stderr = %v", arguments, status, stderr)
+		}
+		if err := json.Unmarshal([]byte(stdout), &decoded); err != nil {
+			t.Fatalf("%v: %v in %q", arguments, err, stdout)
+		}
+		if fmt.Sprint(len(decoded)) != test.count {
+			t.Errorf("%v: %d passwords, want %v", arguments, len(decoded), test.count)
+		}
+		for _, password := range decoded {
+			if password.Password == "" {
+				t.Errorf("%v: an empty password", arguments)
+			}
+			if (password.Entropy > 0) != test.entropy {
+				t.Errorf("%v: entropy %v", arguments, password.Entropy)
+			}
+		}
+	}
+}
+
+func TestMaxIdenticalAdjacent(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	for value, want := range map[string]int{
+		"":		0,
+		"abc":		1,
+		"ab---cd":	3,
+		"sss-book":	3,
+		"ééé1":		3,
+		"aab":		2,
+	} {
+		if got := longest_identical_run([]byte(value)); got != want {
+			t.Errorf("longest_identical_run(%q) = %d, want %d", value, got, want)
+		}
+	}
+
+	// Doubled letters such as the oo in book are common in the words, so
+	// most passwords have to be regenerated
+	defaults.PaddingType = PaddingNone
+	defaults.PaddingDigitsBefore = 0
+	defaults.PaddingDigitsAfter = 0
+	defaults.CaseTransform = CaseLower
+	defaults.SeparatorCharacter = SeparatorCharacter
+	defaults.SeparatorAlphabet = []string{ "-" }
+	config := write_config(t, defaults)
+	status, stdout, stderr := run_capture("", "-config", config, "-max-identical-adjacent-chars", "1", "50")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, password := range output_lines(stdout) {
+		if longest_identical_run([]byte(password)) > 1 {
+			t.Errorf("%q has identical adjacent characters", password)
+		}
+	}
+}
+
+func TestValidateFlag(t *testing.T) {
+
+	var defaults Defaults = default_defaults()
+
+	config := write_config(t, defaults)
+	for _, arguments := range [][]string{
+		{ "-no-config", "-validate" },
+		{ "-config", config, "-validate" },
+		{ "-config", config, "-validate", "-words", "6" },
+	} {
+		status, stdout, stderr := run_capture("", arguments...)
+		if status != ExitOK || stdout != "" || stderr != "" {
+			t.Errorf("%v: status = %d, stdout = %q, stderr = %q", arguments, status, stdout, stderr)
+		}
+	}
+
+	// Each file is valid on its own, but not with the overrides
+	for _, test := range []struct {
+		arguments		[]string
+		problem			string
+	}{
+		{ []string{ "-config", config, "-validate", "-min-length", "9", "-max-length", "4" }, "word_length_min (9) is greater than word_length_max (4)" },
+		{ []string{ "-no-config", "-validate", "-min-length", "40" }, "word_length_min" },
+	} {
+		status, stdout, stderr := run_capture("", test.arguments...)
+		if status != ExitConfig || stdout != "" || !strings.Contains(stderr, test.problem) {
+			t.Errorf("%v: status = %d, stdout = %q, stderr = %q, want %v", test.arguments, status, stdout, stderr, test.problem)
+		}
+	}
+}
+
+func TestCustomDictionary(t *testing.T) {
+
+	var (
+		directory string = t.TempDir()
+		custom string = filepath.Join(directory, "custom.txt")
+		empty string = filepath.Join(directory, "empty.txt")
+		words []string = []string{ "alpha", "bravo", "charlie", "delta" }
+	)
+
+	if err := os.WriteFile(custom, []byte(strings.Join(words, "\n") + "\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(empty, []byte("\n# nothing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-dictionary", custom, "-min-length", "5", "-max-length", "7", "-case", "lower", "-format", "{{range .WordList}}{{.}} {{end}}", "10")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		for _, word := range strings.Fields(line) {
+			if !strings.Contains(" " + strings.Join(words, " ") + " ", " " + word + " ") {
+				t.Errorf("%q is not from the custom dictionary", word)
+			}
+		}
+	}
+
+	for _, name := range []string{ empty, filepath.Join(directory, "missing.txt") } {
+		status, _, stderr = run_capture("", "-no-config", "-dictionary", name)
+		if status != ExitDictionary || !strings.Contains(stderr, "Error reading dictionary") {
+			t.Errorf("%v: status = %d, stderr = %v", name, status, stderr)
+		}
+	}
+}
+
+func TestReadDictionaryErrors(t *testing.T) {
+
+	var (
+		directory string = t.TempDir()
+		valid string = filepath.Join(directory, "valid.json")
+		malformed string = filepath.Join(directory, "malformed.json")
+		empty string = filepath.Join(directory, "empty.json")
+	)
+
+	for name, content := range map[string]string{
+		valid:		`["able", "bake"]`,
+		malformed:	`["able", "bake"`,
+		empty:		`[]`,
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	words, err := read_dictionary(valid)
+	if err != nil || strings.Join(words, " ") != "able bake" {
+		t.Errorf("valid: %q, %v", words, err)
+	}
+	if _, err = read_dictionary(filepath.Join(directory, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing: %v, want a file not found error", err)
+	}
+	if _, err = read_dictionary(malformed); err == nil || !strings.Contains(err.Error(), "Could not parse") {
+		t.Errorf("malformed: %v, want a parse error", err)
+	}
+	if _, err = read_dictionary(empty); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("empty: %v, want an empty dictionary error", err)
+	}
+}
+
+func TestTextAndJSONDictionariesMatch(t *testing.T) {
+
+	var (
+		directory string = t.TempDir()
+		jsonList string = filepath.Join(directory, "words.json")
+		textList string = filepath.Join(directory, "words.txt")
+	)
+
+	if err := os.WriteFile(jsonList, []byte("[\"able\", \"bake\", \"cart\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(textList, []byte("# A comment\nable\n\n  bake  \r\n#cart\ncart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fromJSON, err := read_dictionary(jsonList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromText, err := read_dictionary(textList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fromText, " ") != strings.Join(fromJSON, " ") {
+		t.Errorf("the text list gives %q, the JSON list %q", fromText, fromJSON)
+	}
+}
+
+func TestMinEntropy(t *testing.T) {
+
+	_, stdout, _ := run_capture("", "entropy", "-no-config")
+	entropy, err := strconv.ParseFloat(strings.TrimSpace(stdout), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-min-entropy", fmt.Sprint(entropy - 1), "2")
+	if status != ExitOK || len(output_lines(stdout)) != 2 {
+		t.Errorf("below %.2f bits: status = %d, stdout = %q, stderr = %v", entropy, status, stdout, stderr)
+	}
+	status, stdout, stderr = run_capture("", "-no-config", "-min-entropy", fmt.Sprint(entropy + 1), "2")
+	if status != ExitImpossible || stdout != "" || !strings.Contains(stderr, "regenerating cannot help") {
+		t.Errorf("above %.2f bits: status = %d, stdout = %q, stderr = %v", entropy, status, stdout, stderr)
+	}
+	// More words reach the floor the defaults fall short of
+	status, _, stderr = run_capture("", "-no-config", "-words", "5", "-min-entropy", fmt.Sprint(entropy + 1))
+	if status != ExitOK {
+		t.Errorf("five words: status = %d, stderr = %v", status, stderr)
+	}
+}
+
+func TestNoConfigIgnoresDefaultsFile(t *testing.T) {
+
+	var (
+		home string = t.TempDir()
+		defaults Defaults = default_defaults()
+	)
+
+	defaults.NumWords = 6
+	jsonData, err := json.Marshal(to_json_defaults(defaults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(home, ".xkcd-defaults.json"), jsonData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("XKCD_DEFAULTS", "")
+
+	status, stdout, stderr := run_capture("", "-format", "{{len .WordList}}")
+	if status != ExitOK || stdout != "6\n" {
+		t.Errorf("without -no-config: status = %d, stdout = %q, stderr = %v", status, stdout, stderr)
+	}
+	status, stdout, stderr = run_capture("", "-no-config", "-format", "{{len .WordList}}")
+	if status != ExitOK || stdout != fmt.Sprintf("%d\n", default_defaults().NumWords) {
+		t.Errorf("with -no-config: status = %d, stdout = %q, stderr = %v", status, stdout, stderr)
+	}
+}
+
+func TestFuzzyLength(t *testing.T) {
+
+	var lengths = map[int]bool{}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-fuzzy-length", "40-48", "100")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, password := range output_lines(stdout) {
+		if len(password) < 40 || len(password) > 48 {
+			t.Errorf("%q is %d characters long, outside 40-48", password, len(password))
+		}
+		lengths[len(password)] = true
+	}
+	if len(lengths) < 2 {
+		t.Errorf("every password is the same length: %v", lengths)
+	}
+
+	for _, value := range []string{ "48-40", "0-10", "40", "a-b" } {
+		if status, _, _ = run_capture("", "-no-config", "-fuzzy-length", value); status != ExitUsage {
+			t.Errorf("-fuzzy-length %v: status = %d, want %d", value, status, ExitUsage)
+		}
+	}
+}
+
+func TestLogLevelSuppressesLowerLevels(t *testing.T) {
+
+	var runOn = []string{ "-no-config", "-words", "2", "-separator", "none", "-case", "lower" }
+
+	for _, test := range []struct {
+		level		string
+		shown		[]string
+		hidden		[]string
+	}{
+		{ "error", nil, []string{ "warning:", "info:", "debug:" } },
+		{ "warn", []string{ "warning:" }, []string{ "info:", "debug:" } },
+		{ "info", []string{ "warning:", "info:" }, []string{ "debug:" } },
+		{ "debug", []string{ "warning:", "info:", "debug:" }, nil },
+	} {
+		status, _, stderr := run_capture("", append([]string{ "-log-level", test.level }, runOn...)...)
+		if status != ExitOK {
+			t.Fatalf("%v: status = %d, stderr = %v", test.level, status, stderr)
+		}
+		for _, prefix := range test.shown {
+			if !strings.Contains(stderr, prefix) {
+				t.Errorf("-log-level %v: no %v messages in %q", test.level, prefix, stderr)
+			}
+		}
+		for _, prefix := range test.hidden {
+			if strings.Contains(stderr, prefix) {
+				t.Errorf("-log-level %v: %v messages were not suppressed: %q", test.level, prefix, stderr)
+			}
+		}
+	}
+
+	if status, _, _ := run_capture("", "-no-config", "-log-level", "loud"); status != ExitUsage {
+		t.Errorf("-log-level loud: status = %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestWordRegex(t *testing.T) {
+
+	words, err := filter_words_by_regex([]string{ "able", "don't", "bake", "o'clock", "cart" }, `^[a-z]+$`)
+	if err != nil || strings.Join(words, " ") != "able bake cart" {
+		t.Errorf("filter_words_by_regex = %q, %v, want the words without apostrophes", words, err)
+	}
+	if _, err = filter_words_by_regex(nil, "("); err == nil || !strings.Contains(err.Error(), "Invalid word-regex") {
+		t.Errorf("an invalid pattern gives %v", err)
+	}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-word-regex", "e$", "-case", "lower", "-format", "{{range .WordList}}{{.}} {{end}}", "20")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		for _, word := range strings.Fields(line) {
+			if !strings.HasSuffix(word, "e") {
+				t.Errorf("%q uses %q, which does not match e$", line, word)
+			}
+		}
+	}
+
+	// Only a handful of the words are left, so it warns
+	status, _, stderr = run_capture("", "-no-config", "-word-regex", "^ab", "1")
+	if status != ExitOK || !strings.Contains(stderr, "word-regex leaves only") {
+		t.Errorf("a narrow pattern: status = %d, stderr = %v", status, stderr)
+	}
+	if status, _, stderr = run_capture("", "-no-config", "-word-regex", "("); status != ExitUsage {
+		t.Errorf("an invalid pattern: status = %d, stderr = %v", status, stderr)
+	}
+}
+
+func TestRunHookReadsStdin(t *testing.T) {
+
+	var (
+		directory string = t.TempDir()
+		received string = filepath.Join(directory, "received")
+		copied string = filepath.Join(directory, "copied")
+		output bytes.Buffer
+	)
+
+	if err := run_hook("cat > " + received, "correct-horse", &output); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(received); err != nil || string(content) != "correct-horse\n" {
+		t.Errorf("the hook read %q, %v from stdin", content, err)
+	}
+	// {} names stdin for commands which only read files
+	if err := run_hook("cp {} " + copied, "battery-staple", &output); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(copied); err != nil || string(content) != "battery-staple\n" {
+		t.Errorf("the hook copied %q, %v", content, err)
+	}
+	if err := run_hook("exit 3", "correct-horse", &output); err == nil || !strings.Contains(err.Error(), "status 3") {
+		t.Errorf("a failing hook gives %v, want its exit status", err)
+	}
+
+	// Every password reaches the hook, and a failing hook fails the run
+	os.Remove(received)
+	status, stdout, stderr := run_capture("", "-no-config", "-on-generate", "cat >> " + received, "3")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	if content, err := os.ReadFile(received); err != nil || string(content) != stdout {
+		t.Errorf("the hook read %q, %v, want the passwords %q", content, err, stdout)
+	}
+	status, _, stderr = run_capture("", "-no-config", "-on-generate", "exit 1", "2")
+	if status != ExitError || !strings.Contains(stderr, "on-generate failed for 2 of 2 passwords") {
+		t.Errorf("a failing hook: status = %d, stderr = %v", status, stderr)
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+
+	var (
+		lists [][]string = [][]string{
+			{ "able", "bake", "cart", "bake" },
+			{ "cart", "dome", "bake" },
+		}
+		tests = []struct {
+			strategy		string
+			want			string
+		}{
+			{ "union",	"able bake cart dome" },
+			{ "intersect",	"bake cart" },
+			{ "concat",	"able bake cart bake cart dome bake" },
+		}
+	)
+
+	for _, test := range tests {
+		merged, err := merge_dictionaries(lists, test.strategy)
+		if err != nil || strings.Join(merged, " ") != test.want {
+			t.Errorf("%v: %q, %v, want %q", test.strategy, merged, err, test.want)
+		}
+	}
+	if _, err := merge_dictionaries(lists, "shuffle"); err == nil {
+		t.Errorf("shuffle: no error")
+	}
+
+	// Only bake and cart are in both, so only they can be drawn
+	directory := t.TempDir()
+	first, second := filepath.Join(directory, "first.txt"), filepath.Join(directory, "second.txt")
+	if err := os.WriteFile(first, []byte(strings.Join(lists[0], "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte(strings.Join(lists[1], "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, stderr := run_capture("", "-no-config", "-dictionary", first, "-dictionary", second, "-merge-strategy", "intersect", "-min-length", "4", "-max-length", "4", "-case", "lower", "-format", "{{range .WordList}}{{.}} {{end}}", "10")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, word := range strings.Fields(stdout) {
+		if word != "bake" && word != "cart" {
+			t.Errorf("intersect drew %q, which is not in both lists", word)
+		}
+	}
+	if status, _, _ = run_capture("", "-no-config", "-dictionary", first, "-merge-strategy", "shuffle"); status != ExitDictionary {
+		t.Errorf("-merge-strategy shuffle: status = %d, want %d", status, ExitDictionary)
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+
+	var tests = []struct {
+		arguments		[]string
+		command			string
+		rest			string
+		valid			bool
+	}{
+		{ []string{}, "generate", "", true },
+		{ []string{ "-no-config", "2" }, "generate", "-no-config 2", true },
+		{ []string{ "generate", "2" }, "generate", "2", true },
+		{ []string{ "entropy", "-no-config" }, "entropy", "-no-config", true },
+		{ []string{ "config", "print" }, "config print", "", true },
+		{ []string{ "config", "validate", "-words", "4" }, "config validate", "-words 4", true },
+		{ []string{ "config" }, "", "", false },
+		{ []string{ "config", "edit" }, "", "", false },
+	}
+
+	for _, test := range tests {
+		command, rest, err := parse_subcommand(test.arguments)
+		if (err == nil) != test.valid || command != test.command || strings.Join(rest, " ") != test.rest {
+			t.Errorf("parse_subcommand(%q) = %q, %q, %v", test.arguments, command, rest, err)
+		}
+	}
+
+	// Each subcommand runs its own code path
+	status, stdout, _ := run_capture("", "generate", "-no-config", "2")
+	if status != ExitOK || len(output_lines(stdout)) != 2 {
+		t.Errorf("generate: status = %d, stdout = %q", status, stdout)
+	}
+	status, stdout, _ = run_capture("", "entropy", "-no-config")
+	if _, err := strconv.ParseFloat(strings.TrimSpace(stdout), 64); status != ExitOK || err != nil {
+		t.Errorf("entropy: status = %d, stdout = %q", status, stdout)
+	}
+	var printed map[string]interface{}
+	status, stdout, _ = run_capture("", "config", "print", "-no-config", "-words", "4")
+	if err := json.Unmarshal([]byte(stdout), &printed); status != ExitOK || err != nil || printed["num_words"] != 4.0 {
+		t.Errorf("config print: status = %d, stdout = %q", status, stdout)
+	}
+	status, stdout, _ = run_capture("", "config", "validate", "-no-config")
+	if status != ExitOK || stdout != "" {
+		t.Errorf("config validate: status = %d, stdout = %q", status, stdout)
+	}
+	status, _, _ = run_capture("", "config", "validate", "-no-config", "-min-length", "9", "-max-length", "4")
+	if status != ExitConfig {
+		t.Errorf("config validate of invalid defaults: status = %d, want %d", status, ExitConfig)
+	}
+	if status, _, _ = run_capture("", "config"); status != ExitUsage {
+		t.Errorf("config alone: status = %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestStrictConfig(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		fields map[string]interface{}
+		config string = filepath.Join(t.TempDir(), "typo.json")
+	)
+
+	jsonData, err := json.Marshal(to_json_defaults(defaults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(jsonData, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["num_word"] = 6
+	if jsonData, err = json.Marshal(fields); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(config, jsonData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = read_defaults(jsonData, false); err != nil {
+		t.Errorf("lenient: %v", err)
+	}
+	if _, err = read_defaults(jsonData, true); err == nil || !strings.Contains(err.Error(), "num_word") {
+		t.Errorf("strict: %v, want an error naming num_word", err)
+	}
+
+	if status, _, stderr := run_capture("", "-config", config); status != ExitOK {
+		t.Errorf("lenient run: status = %d, stderr = %v", status, stderr)
+	}
+	if status, _, stderr := run_capture("", "-config", config, "-strict-config"); status != ExitConfig || !strings.Contains(stderr, "num_word") {
+		t.Errorf("strict run: status = %d, stderr = %v", status, stderr)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+
+	var (
+		base Defaults = default_defaults()
+		resolved Defaults
+	)
+
+	resolved = base
+	resolved.NumWords = 4
+	provenance, err := defaults_provenance(base, map[string]bool{ "num_words": true, "word_length_min": true }, "preset", resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"num_words":		"flag",
+		"word_length_min":	"preset",
+		"word_length_max":	"default",
+	} {
+		if provenance[key] != want {
+			t.Errorf("%v is from %q, want %q", key, provenance[key], want)
+		}
+	}
+
+	config := write_config(t, base)
+	status, _, stderr := run_capture("", "-config", config, "-words", "4", "-debug")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, annotation := range []string{ "num_words=4 (from flag)", "word_length_min=4 (from file)" } {
+		if !strings.Contains(stderr, annotation) {
+			t.Errorf("the debug output has no %q", annotation)
+		}
+	}
+}
+
+func TestLeet(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		word string = strings.Repeat("aeiost", 200)
+	)
+
+	// Disabled, the word is left alone
+	if got, err := leet(defaults, word); err != nil || got != word {
+		t.Errorf("without leet: %q, %v", got, err)
+	}
+
+	defaults.Random = seeded_random(t, "1ee7")
+	defaults.LeetProbability = 1
+	if got, err := leet(defaults, "Toast"); err != nil || got != "70@$7" {
+		t.Errorf("with a probability of 1: %q, %v, want 70@$7", got, err)
+	}
+
+	// With half, about half of the letters change, and only to their
+	// substitutions
+	defaults.LeetProbability = 0.5
+	got, err := leet(defaults, word)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var substituted int = 0
+	for i, r := range []rune(got) {
+		original := rune(word[i])
+		if r != original {
+			if string(r) != defaultLeetMap[original] {
+				t.Fatalf("%q became %q", original, r)
+			}
+			substituted++
+		}
+	}
+	if fraction := float64(substituted) / float64(len(word)); fraction < 0.4 || fraction > 0.6 {
+		t.Errorf("%.2f of the letters were substituted, want about half", fraction)
+	}
+
+	status, stdout, stderr := run_capture("", "-no-config", "-leet", "-min-length", "8", "-max-length", "8", "-words", "6", "-case", "lower", "-format", "{{range .WordList}}{{.}} {{end}}", "5")
+	if status != ExitOK || !strings.ContainsAny(stdout, "@3107$") {
+		t.Errorf("-leet: status = %d, stdout = %q, stderr = %v", status, stdout, stderr)
+	}
+}
+
+func TestSyllableMode(t *testing.T) {
+
+	var syllable *regexp.Regexp = regexp.MustCompile("^([" + syllableConsonants + "][" + syllableVowels + "])+$")
+
+	for _, syllables := range []int{ 1, 2, 4 } {
+		word, err := random_syllable_word(seeded_random(t, "5711"), syllables)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(word) != 2 * syllables || !syllable.MatchString(word) {
+			t.Errorf("%d syllables gives %q", syllables, word)
+		}
+	}
+
+	// The syllable words still go through the separators, digits and
+	// padding
+	var password *regexp.Regexp = regexp.MustCompile(`^[^a-z0-9]{2}\d{4}-([a-z]{6})-([a-z]{6})-([a-z]{6})-\d{5}[^a-z0-9]{3}$`)
+	status, stdout, stderr := run_capture("", "-no-config", "-mode", "syllable", "-syllables", "3", "-separator", "-", "-case", "lower", "10")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		match := password.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("%q is not padding, digits and three syllable words", line)
+			continue
+		}
+		for _, word := range match[1:] {
+			if !syllable.MatchString(word) {
+				t.Errorf("%q in %q is not made of syllables", word, line)
+			}
+		}
+	}
+}
+
+func TestGuessTime(t *testing.T) {
+
+	var tests = []struct {
+		bits float64
+		rate float64
+		pattern string
+	}{
+		{ 0, 1e10, "^instantly$" },
+		{ 0.5, 1e10, "^instantly$" },
+		{ 1, 1e10, "^less than a second$" },
+		{ 34, 1e10, "^less than a second$" },
+		{ 40, 1e10, "^~\\d+ seconds$" },
+		{ 50, 1e10, "^~\\d+ hours$" },
+		{ 20, 1, "^~\\d+ days$" },
+		{ 60, 1e10, "^~\\d+ years$" },
+		{ 34, 1, "^~\\d+ centuries$" },
+		{ 70, 1e10, "^~\\d+ millennia$" },
+		{ 128, 1e10, "^~10\\^20 years$" },
+		// Far beyond what a float64 of seconds holds
+		{ 100000, 1e10, "^~10\\^30085 years$" },
+	}
+
+	for _, test := range tests {
+		got := guess_time(test.bits, test.rate)
+		if !regexp.MustCompile(test.pattern).MatchString(got) {
+			t.Errorf("guess_time(%v, %g) = %q, want %v", test.bits, test.rate, got, test.pattern)
+		}
+	}
+
+	// One more bit doubles the time
+	if guess_time(61, 1e10) == guess_time(60, 1e10) {
+		t.Errorf("guess_time(61) = guess_time(60) = %q", guess_time(60, 1e10))
+	}
+
+	status, _, stderr := run_capture("", "-no-config", "-estimate", "-guess-rate", "1000", "1")
+	if status != ExitOK || !strings.Contains(stderr, "estimated time to guess: ") || !strings.Contains(stderr, "at 1000 guesses/sec") {
+		t.Errorf("-estimate: status = %d, stderr = %q", status, stderr)
+	}
+}
+
+func TestOutputTerminators(t *testing.T) {
+
+	// The format makes every password "4" so the raw bytes are known
+	var tests = []struct {
+		args []string
+		want string
+	}{
+		{ []string{ "3" }, "4\n4\n4\n" },
+		{ []string{ "-no-trailing-newline", "1" }, "4" },
+		{ []string{ "-no-trailing-newline", "3" }, "4\n4\n4" },
+		{ []string{ "-print0", "3" }, "4\x004\x004\x00" },
+		{ []string{ "-password-separator", ",", "3" }, "4,4,4\n" },
+		{ []string{ "-password-separator", "\\t", "-no-trailing-newline", "3" }, "4\t4\t4" },
+		{ []string{ "-password-separator", "\\x00", "2" }, "4\x004\n" },
+	}
+
+	for _, test := range tests {
+		args := append([]string{ "-no-config", "-words", "4", "-format", "{{len .WordList}}" }, test.args...)
+		status, stdout, stderr := run_capture("", args...)
+		if status != ExitOK || stdout != test.want {
+			t.Errorf("%v: status = %d, stdout = %q, want %q, stderr = %v", test.args, status, stdout, test.want, stderr)
+		}
+	}
+
+	for _, args := range [][]string{
+		{ "-print0", "-no-trailing-newline" },
+		{ "-print0", "-password-separator", "," },
+		{ "-print0", "-json" },
+		{ "-password-separator", "\\q" },
+	} {
+		status, _, _ := run_capture("", append([]string{ "-no-config" }, args...)...)
+		if status != ExitUsage {
+			t.Errorf("%v: status = %d, want %d", args, status, ExitUsage)
+		}
+	}
+}
+
+func TestMultibyteSeparatorsAndSymbols(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		password *regexp.Regexp = regexp.MustCompile(`^[€£]+\d+([·—][a-z]+){3}[·—]\d+[€£]+$`)
+	)
+
+	defaults.NumWords = 3
+	defaults.CaseTransform = CaseLower
+	defaults.SeparatorCharacter = SeparatorRandom
+	defaults.SeparatorAlphabet = []string{ "·", "—" }
+	defaults.PaddingCharacter = PaddingRandom
+	defaults.SymbolAlphabet = []string{ "€", "£" }
+	config := write_config(t, defaults)
+
+	// Read back from the defaults file and kept whole in the passwords
+	status, stdout, stderr := run_capture("", "-config", config, "-strict-config", "20")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		if !utf8.ValidString(line) || !password.MatchString(line) {
+			t.Errorf("%q is not multibyte padding and separators around three words", line)
+		}
+	}
+
+	// The same from the flags
+	status, stdout, stderr = run_capture("", "-no-config", "-words", "3", "-case", "lower", "-separator", "—", "-symbol-alphabet", "€", "5")
+	if status != ExitOK {
+		t.Fatalf("flags: status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		if !password.MatchString(line) || strings.Contains(line, "·") || strings.Contains(line, "£") {
+			t.Errorf("%q is not € padding and — separators", line)
+		}
+	}
+
+	// Two characters are still not one separator
+	status, _, _ = run_capture("", "-no-config", "-separator", "·—")
+	if status == ExitOK {
+		t.Errorf("-separator ·— was accepted")
+	}
+	defaults.SymbolAlphabet = []string{ "€£" }
+	status, _, _ = run_capture("", "-config", write_config(t, defaults))
+	if status == ExitOK {
+		t.Errorf("symbol_alphabet entry €£ was accepted")
+	}
+}
+
+func TestDigitPlacement(t *testing.T) {
+
+	var (
+		placements = map[string]string{
+			"both":		`^\d{2}-W-W-W-\d{3}$`,
+			"before":	`^\d{2}-W-W-W$`,
+			"after":	`^W-W-W-\d{3}$`,
+			"between-all":	`^W-\d{3}-W-\d{3}-W$`,
+			"random-gap":	`^(W-\d{3}-W-W|W-W-\d{3}-W)$`,
+		}
+		padding *regexp.Regexp = regexp.MustCompile(`^#*(.*?)#*$`)
+		firstGap *regexp.Regexp = regexp.MustCompile(`^[a-z]+-\d`)
+		gaps = map[bool]bool{}
+	)
+
+	for placement, pattern := range placements {
+		password := regexp.MustCompile(strings.ReplaceAll(pattern, "W", "[a-z]+"))
+		status, stdout, stderr := run_capture("", "-no-config", "-words", "3", "-case", "lower", "-separator", "-", "-symbol-alphabet", "#", "-digits-before", "2", "-digits-after", "3", "-digit-placement", placement, "20")
+		if status != ExitOK {
+			t.Fatalf("%v: status = %d, stderr = %v", placement, status, stderr)
+		}
+		for _, line := range output_lines(stdout) {
+			// Without the padding symbols
+			inner := padding.FindStringSubmatch(line)[1]
+			if !password.MatchString(inner) {
+				t.Errorf("%v: %q does not match %v", placement, line, pattern)
+			}
+			if placement == "random-gap" {
+				gaps[firstGap.MatchString(inner)] = true
+			}
+		}
+	}
+	if len(gaps) != 2 {
+		t.Errorf("random-gap always used the same gap")
+	}
+
+	// Digits between words need two words
+	status, _, _ := run_capture("", "-no-config", "-words", "1", "-digit-placement", "between-all")
+	if status == ExitOK {
+		t.Errorf("between-all with one word was accepted")
+	}
+	status, _, _ = run_capture("", "-no-config", "-digit-placement", "middle")
+	if status == ExitOK {
+		t.Errorf("an unknown digit-placement was accepted")
+	}
+}
+
+func TestDegeneratePadding(t *testing.T) {
+
+	var tests = []struct {
+		name string
+		config func(*Defaults)
+		fields []string
+		problems int
+		status int
+		strictStatus int
+	}{
+		{ "fixed with no characters", func(d *Defaults) { d.PaddingType = PaddingFixed; d.PaddingCharactersBefore = 0; d.PaddingCharactersAfter = 0 }, []string{ "padding_characters_before", "padding_characters_after" }, 1, ExitOK, ExitConfig },
+		{ "multiple of one", func(d *Defaults) { d.PaddingType = PaddingMultiple; d.PadToMultiple = 1 }, []string{ "pad_to_multiple" }, 1, ExitOK, ExitConfig },
+		// Always an error since it pads to nothing
+		{ "adaptive to no length", func(d *Defaults) { d.PaddingType = PaddingAdaptive; d.PadToLength = 0 }, []string{ "pad_to_length" }, 0, ExitConfig, ExitConfig },
+		{ "fixed", func(d *Defaults) { d.PaddingType = PaddingFixed; d.PaddingCharactersBefore = 0; d.PaddingCharactersAfter = 2 }, nil, 0, ExitOK, ExitOK },
+	}
+
+	for _, test := range tests {
+		defaults := default_defaults()
+		test.config(&defaults)
+		config := write_config(t, defaults)
+
+		if len(degenerate_padding(defaults)) != test.problems {
+			t.Errorf("%v: degenerate_padding = %q", test.name, degenerate_padding(defaults))
+		}
+
+		status, _, stderr := run_capture("", "-config", config)
+		if status != test.status {
+			t.Errorf("%v: status = %d, want %d, stderr = %v", test.name, status, test.status, stderr)
+		}
+		for _, field := range test.fields {
+			if !strings.Contains(stderr, field) {
+				t.Errorf("%v: %q does not name %v", test.name, stderr, field)
+			}
+		}
+		if test.fields == nil && stderr != "" {
+			t.Errorf("%v: unexpected warning %q", test.name, stderr)
+		}
+
+		status, _, stderr = run_capture("", "-config", config, "-strict-config")
+		if status != test.strictStatus {
+			t.Errorf("%v with -strict-config: status = %d, want %d, stderr = %v", test.name, status, test.strictStatus, stderr)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+
+	var (
+		config string = t.TempDir()
+		profiles string = filepath.Join(config, "xkcd-passwd")
+		saved = userConfigDir
+	)
+
+	userConfigDir = func() (string, error) {
+		return config, nil
+	}
+	defer func() {
+		userConfigDir = saved
+	}()
+
+	// No profiles at all
+	status, _, stderr := run_capture("", "-profile", "work")
+	if status != ExitConfig || !strings.Contains(stderr, "no profiles in") {
+		t.Errorf("no profiles: status = %d, stderr = %v", status, stderr)
+	}
+
+	if err := os.MkdirAll(profiles, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, words := range map[string]int{ "work": 3, "home": 6 } {
+		defaults := default_defaults()
+		defaults.NumWords = words
+		if err := os.Rename(write_config(t, defaults), filepath.Join(profiles, name + ".json")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{ "work": "3", "home": "6" } {
+		status, stdout, stderr := run_capture("", "-profile", name, "-format", "{{len .WordList}}")
+		if status != ExitOK || stdout != want + "\n" {
+			t.Errorf("-profile %v: status = %d, stdout = %q, want %v words, stderr = %v", name, status, stdout, want, stderr)
+		}
+	}
+
+	// A missing profile lists the ones there are
+	status, _, stderr = run_capture("", "-profile", "school")
+	if status != ExitConfig || !strings.Contains(stderr, "no profile school") || !strings.Contains(stderr, "home, work") {
+		t.Errorf("-profile school: status = %d, stderr = %v", status, stderr)
+	}
+
+	status, _, _ = run_capture("", "-profile", "work", "-no-config")
+	if status != ExitUsage {
+		t.Errorf("-profile with -no-config: status = %d, want %d", status, ExitUsage)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+
+	var (
+		defaults Defaults = default_defaults()
+		directory string = t.TempDir()
+		malformed string = filepath.Join(directory, "malformed.json")
+		unknown string = filepath.Join(directory, "unknown.json")
+	)
+
+	// A valid file prints nothing at all
+	status, stdout, stderr := run_capture("", "config", "validate", "-config", write_config(t, defaults))
+	if status != ExitOK || stdout != "" || stderr != "" {
+		t.Errorf("valid: status = %d, stdout = %q, stderr = %q", status, stdout, stderr)
+	}
+
+	// Every problem in the file is reported at once
+	defaults.LeetProbability = 2
+	defaults.PaddingType = PaddingAdaptive
+	defaults.PadToLength = 0
+	status, stdout, stderr = run_capture("", "config", "validate", "-config", write_config(t, defaults))
+	if status != ExitConfig || stdout != "" || !strings.Contains(stderr, "leet_probability") || !strings.Contains(stderr, "pad_to_length") {
+		t.Errorf("out of range: status = %d, stdout = %q, stderr = %q", status, stdout, stderr)
+	}
+
+	if err := os.WriteFile(malformed, []byte(`{ "num_words": 3, `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(unknown, []byte(`{ "case_transform": "sideways" }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for filename, problem := range map[string]string{
+		malformed:					"unexpected EOF",
+		unknown:					"sideways",
+		filepath.Join(directory, "missing.json"):	"no such file",
+	} {
+		status, stdout, stderr = run_capture("", "config", "validate", "-config", filename)
+		if status != ExitConfig || stdout != "" || !strings.Contains(stderr, problem) {
+			t.Errorf("%v: status = %d, stdout = %q, stderr = %q, want %v", filepath.Base(filename), status, stdout, stderr, problem)
+		}
+	}
+}
+
+func TestAlternateCaseSkipsNonLetters(t *testing.T) {
+
+	var (
+		tests = map[string]string{
+			"horse":	"HoRsE",
+			"o'neil":	"O'nEiL",
+			"rock-and":	"RoCk-AnD",
+			"don't":	"DoN't",
+			"'tis":		"'TiS",
+			"x-ray":	"X-rAy",
+			"ça-va":	"Ça-Va",
+		}
+		custom string = filepath.Join(t.TempDir(), "custom.txt")
+	)
+
+	for word, want := range tests {
+		got, err := transform_case(seeded_random(t, "0a"), word, CaseAlternate)
+		if err != nil || got != want {
+			t.Errorf("transform_case(%q) = %q, %v, want %q", word, got, err, want)
+		}
+	}
+
+	// The same for words from a dictionary
+	if err := os.WriteFile(custom, []byte("o'neil\nrock-and\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, stderr := run_capture("", "-no-config", "-dictionary", custom, "-words", "2", "-min-length", "4", "-max-length", "10", "-case", "alternate", "-format", "{{range .WordList}}{{.}} {{end}}", "5")
+	if status != ExitOK {
+		t.Fatalf("status = %d, stderr = %v", status, stderr)
+	}
+	for _, line := range output_lines(stdout) {
+		for _, word := range strings.Fields(line) {
+			if word != "O'nEiL" && word != "RoCk-AnD" {
+				t.Errorf("%q in %q is not alternated across its letters", word, line)
+			}
+		}
+	}
+}